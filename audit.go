@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// auditLogSize is the number of audit entries kept.
+const auditLogSize = 10000
+
+// AuditEntry is either a 9P operation or a REST call. REST calls are
+// recorded as they complete, so the calls caused by a 9P operation precede
+// the entry of the operation itself. REST calls carry the user and path of
+// the 9P operation that caused them, and have neither for background work
+// such as mirror syncs.
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	User string    `json:"user,omitempty"`
+	Path string    `json:"path,omitempty"`
+
+	// 9P operations.
+	Op      string `json:"op,omitempty"`
+	Target  string `json:"target,omitempty"`
+	Command string `json:"command,omitempty"`
+
+	// REST calls.
+	Method string `json:"method,omitempty"`
+	URL    string `json:"url,omitempty"`
+	Status int    `json:"status,omitempty"`
+
+	Latency float64 `json:"latency_ms"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// AuditLog keeps the latest audit entries while enabled. The zero value is
+// a disabled log.
+type AuditLog struct {
+	sync.Mutex
+	enabled bool
+	entries []AuditEntry
+}
+
+func (al *AuditLog) SetEnabled(enabled bool) {
+	al.Lock()
+	defer al.Unlock()
+	al.enabled = enabled
+}
+
+func (al *AuditLog) Record(e AuditEntry) {
+	al.Lock()
+	defer al.Unlock()
+	if !al.enabled {
+		return
+	}
+
+	al.entries = append(al.entries, e)
+	if len(al.entries) > auditLogSize {
+		al.entries = append([]AuditEntry(nil), al.entries[len(al.entries)-auditLogSize:]...)
+	}
+}
+
+func auditEntry(kind string, start time.Time, err error) AuditEntry {
+	e := AuditEntry{
+		Time:    start,
+		Kind:    kind,
+		Latency: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
+}
+
+// Op records a 9P operation on path, started at start.
+func (al *AuditLog) Op(user, path, op string, start time.Time, err error) {
+	e := auditEntry("9p", start, err)
+	e.User, e.Path, e.Op = user, path, op
+	al.Record(e)
+}
+
+// Request records a REST call made for the 9P operation of scope, started
+// at start. Status is 0 if no response was received. Scope is nil for
+// background work.
+func (al *AuditLog) Request(scope *Scope, method, url string, status int, start time.Time, err error) {
+	e := auditEntry("rest", start, err)
+	e.Method, e.URL, e.Status = method, url, status
+	if scope != nil {
+		e.User, e.Path = scope.User, scope.Path
+	}
+	al.Record(e)
+}
+
+// String renders the log as JSON lines.
+func (al *AuditLog) String() string {
+	al.Lock()
+	defer al.Unlock()
+
+	var s string
+	for _, e := range al.entries {
+		b, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		s += string(b) + "\n"
+	}
+	return s
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const defaultBulkConcurrency = 4
+
+// BulkResult is the outcome of a bulk operation on a single issue.
+type BulkResult struct {
+	Key string
+	Err error
+}
+
+// RunBulk applies op to every key, running at most jc.BulkConcurrency()
+// operations at a time. The results are sorted by key.
+func RunBulk(jc *Client, keys []string, op func(key string) error) []BulkResult {
+	concurrency := jc.BulkConcurrency()
+	if concurrency <= 0 {
+		concurrency = defaultBulkConcurrency
+	}
+
+	results := make([]BulkResult, len(keys))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, key string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = BulkResult{Key: key, Err: op(key)}
+		}(i, key)
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Key < results[j].Key
+	})
+	return results
+}
+
+// RenderBulkReport renders the results of a bulk command, one issue per line.
+func RenderBulkReport(cmd string, results []BulkResult) string {
+	var failed, queued int
+	s := fmt.Sprintf("# %s\n", cmd)
+	for _, r := range results {
+		switch {
+		case IsQueued(r.Err):
+			queued++
+			s += fmt.Sprintf("%s queued: %v\n", r.Key, r.Err)
+		case r.Err != nil:
+			failed++
+			s += fmt.Sprintf("%s error: %v\n", r.Key, r.Err)
+		default:
+			s += fmt.Sprintf("%s ok\n", r.Key)
+		}
+	}
+	s += fmt.Sprintf("# %d ok, %d queued, %d failed\n", len(results)-failed-queued, queued, failed)
+	return s
+}
+
+// BulkOp parses a bulk command into an operation to apply to each issue.
+func BulkOp(jc *Client, cmd string, args []string) (func(key string) error, error) {
+	switch cmd {
+	case "set":
+		if len(args) < 1 {
+			return nil, errors.New("field missing")
+		}
+		field, val := args[0], strings.Join(args[1:], " ")
+		return func(key string) error {
+			return SetFieldInIssue(jc, key, field, val)
+		}, nil
+	case "assign":
+		if len(args) != 1 {
+			return nil, errors.New("invalid arguments")
+		}
+		return func(key string) error {
+			return SetFieldInIssue(jc, key, "assignee", args[0])
+		}, nil
+	case "label":
+		var add, remove []string
+		for _, arg := range args {
+			switch {
+			case strings.HasPrefix(arg, "+") && len(arg) > 1:
+				add = append(add, arg[1:])
+			case strings.HasPrefix(arg, "-") && len(arg) > 1:
+				remove = append(remove, arg[1:])
+			default:
+				return nil, fmt.Errorf("expected +label or -label, got %s", arg)
+			}
+		}
+		if len(add)+len(remove) == 0 {
+			return nil, errors.New("labels missing")
+		}
+		return func(key string) error {
+			return UpdateLabels(jc, key, add, remove)
+		}, nil
+	case "transition":
+		name, values, err := ParseTransitionInput(strings.Join(args, " "))
+		if err != nil {
+			return nil, err
+		}
+		return func(key string) error {
+			return TransitionIssueWithFields(jc, key, name, values)
+		}, nil
+	case "comment":
+		if len(args) == 0 {
+			return nil, errors.New("comment missing")
+		}
+		body := strings.Join(args, " ")
+		return func(key string) error {
+			return AddComment(jc, key, body)
+		}, nil
+	default:
+		return nil, errors.New("no such command")
+	}
+}
@@ -11,11 +11,51 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
 
 	"github.com/mrjones/oauth"
 )
 
+// Client is used by the views to talk to JIRA. Clients derived with
+// WithScope share all state with the client they were derived from, but
+// attribute their REST calls to a 9P operation in the audit log.
 type Client struct {
+	*clientState
+	scope *Scope
+}
+
+// Scope is the 9P operation on whose behalf REST calls are made.
+type Scope struct {
+	User string
+	Path string
+}
+
+// WithScope returns a client sharing the state of c, attributing its REST
+// calls to the user and path of a 9P operation.
+func (c *Client) WithScope(user, path string) *Client {
+	return &Client{
+		clientState: c.clientState,
+		scope:       &Scope{User: user, Path: path},
+	}
+}
+
+// Background returns a client sharing the state of c, for work that is not
+// done on behalf of a 9P operation.
+func (c *Client) Background() *Client {
+	return &Client{clientState: c.clientState}
+}
+
+// User returns the 9P user the client acts for, or an empty string for
+// background work.
+func (c *Client) User() string {
+	if c.scope == nil {
+		return ""
+	}
+	return c.scope.User
+}
+
+type clientState struct {
 	*http.Client
 
 	user, pass string
@@ -23,6 +63,86 @@ type Client struct {
 	usingOAuth bool
 
 	maxlisting int
+
+	// bulkConcurrency is the max number of concurrent requests made by bulk
+	// commands, protected by bulkLock.
+	bulkLock        sync.Mutex
+	bulkConcurrency int
+
+	// depsLink and depsDepth control the link type and depth followed by the
+	// deps files of issues, protected by depsLock.
+	depsLock  sync.Mutex
+	depsLink  string
+	depsDepth int
+
+	// trashDir is where deleted issues are archived, and deleteWindow is
+	// how long an armed deletion can be confirmed, protected by
+	// deleteLock. restoreLock serializes restores, so that an archive is
+	// restored at most once.
+	trashDir     string
+	deleteLock   sync.Mutex
+	deleteWindow time.Duration
+	deletes      PendingDeletes
+	restoreLock  sync.Mutex
+
+	// gitDir is the repository written by git exports, and gitLock
+	// serializes exports, which share its index.
+	gitDir  string
+	gitLock sync.Mutex
+
+	// eventInterval is how often events files poll for changes, protected
+	// by eventLock.
+	eventLock     sync.Mutex
+	eventInterval time.Duration
+	events        EventWatchers
+
+	journal   Journal
+	queue     WriteQueue
+	mirror    Mirror
+	audit     AuditLog
+	metrics   Metrics
+	workflows WorkflowCache
+	costs     PathCosts
+	results   ResultStore
+
+	// netLock protects offline, which is set in offline mode.
+	netLock sync.Mutex
+	offline bool
+
+	// fieldLock protects the discovered custom field ids.
+	fieldLock     sync.Mutex
+	epicLinkField string
+
+	// zoneLock protects the time zone of the JIRA user, in which JQL dates
+	// are interpreted.
+	zoneLock sync.Mutex
+	zone     *time.Location
+}
+
+// NetworkError is returned by RPC when JIRA could not be reached, as opposed
+// to JIRA rejecting the request.
+type NetworkError struct {
+	Err error
+}
+
+func (ne *NetworkError) Error() string {
+	return ne.Err.Error()
+}
+
+// IsNetworkError returns whether err is a NetworkError, as returned by RPC or
+// wrapped by wrapError.
+func IsNetworkError(err error) bool {
+	_, ok := err.(*NetworkError)
+	return ok
+}
+
+// wrapError prefixes err with msg like fmt.Errorf, keeping network errors
+// recognizable by IsNetworkError.
+func wrapError(msg string, err error) error {
+	if ne, ok := err.(*NetworkError); ok {
+		return &NetworkError{Err: fmt.Errorf("%s: %v", msg, ne.Err)}
+	}
+	return fmt.Errorf("%s: %v", msg, err)
 }
 
 type RPCError struct {
@@ -68,16 +188,21 @@ func (c *Client) RPC(method, path string, body, target interface{}) error {
 		req.SetBasicAuth(c.user, c.pass)
 	}
 
+	start := time.Now()
 	resp, err := c.Client.Do(req)
 	if err != nil {
-		return err
+		c.audit.Request(c.scope, method, u.String(), 0, start, err)
+		c.metrics.Request(method, u.Path, 0, time.Since(start))
+		return &NetworkError{Err: err}
 	}
 
 	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.audit.Request(c.scope, method, u.String(), resp.StatusCode, start, err)
+	c.metrics.Request(method, u.Path, resp.StatusCode, time.Since(start))
 	if err != nil {
-		return err
+		return &NetworkError{Err: err}
 	}
-	resp.Body.Close()
 
 	if !(resp.StatusCode >= 200 && resp.StatusCode <= 299) {
 		err = &RPCError{
@@ -88,7 +213,11 @@ func (c *Client) RPC(method, path string, body, target interface{}) error {
 		return err
 	}
 
-	if target != nil {
+	switch x := target.(type) {
+	case nil:
+	case *[]byte:
+		*x = respBody
+	default:
 		if err := json.Unmarshal(respBody, target); err != nil {
 			return err
 		}
@@ -98,6 +227,79 @@ func (c *Client) RPC(method, path string, body, target interface{}) error {
 
 }
 
+func (c *Client) SetOffline(offline bool) {
+	c.netLock.Lock()
+	defer c.netLock.Unlock()
+	c.offline = offline
+}
+
+// Offline returns whether jirafs is in offline mode, where reads are served
+// from the mirror and writes are queued.
+func (c *Client) Offline() bool {
+	c.netLock.Lock()
+	defer c.netLock.Unlock()
+	return c.offline
+}
+
+func (c *Client) SetEventInterval(interval time.Duration) {
+	c.eventLock.Lock()
+	defer c.eventLock.Unlock()
+	c.eventInterval = interval
+}
+
+// EventInterval returns how often events files poll for changes.
+func (c *Client) EventInterval() time.Duration {
+	c.eventLock.Lock()
+	defer c.eventLock.Unlock()
+	return c.eventInterval
+}
+
+func (c *Client) SetDeleteWindow(window time.Duration) {
+	c.deleteLock.Lock()
+	defer c.deleteLock.Unlock()
+	c.deleteWindow = window
+}
+
+// DeleteWindow returns how long an armed deletion or move can be confirmed.
+func (c *Client) DeleteWindow() time.Duration {
+	c.deleteLock.Lock()
+	defer c.deleteLock.Unlock()
+	return c.deleteWindow
+}
+
+func (c *Client) SetBulkConcurrency(concurrency int) {
+	c.bulkLock.Lock()
+	defer c.bulkLock.Unlock()
+	c.bulkConcurrency = concurrency
+}
+
+// BulkConcurrency returns the max number of concurrent requests made by bulk
+// commands.
+func (c *Client) BulkConcurrency() int {
+	c.bulkLock.Lock()
+	defer c.bulkLock.Unlock()
+	return c.bulkConcurrency
+}
+
+func (c *Client) SetDepsLink(link string) {
+	c.depsLock.Lock()
+	defer c.depsLock.Unlock()
+	c.depsLink = link
+}
+
+func (c *Client) SetDepsDepth(depth int) {
+	c.depsLock.Lock()
+	defer c.depsLock.Unlock()
+	c.depsDepth = depth
+}
+
+// Deps returns the link type and depth followed by deps files.
+func (c *Client) Deps() (string, int) {
+	c.depsLock.Lock()
+	defer c.depsLock.Unlock()
+	return c.depsLink, c.depsDepth
+}
+
 func (c *Client) oauth(consumerKey, privateKeyFile string) error {
 	pvf, err := ioutil.ReadFile(privateKeyFile)
 	if err != nil {
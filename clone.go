@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// CloneIssue creates a copy of the issue in the project, copying the
+// summary, description, labels, components and links. Components that do not
+// exist in the project are skipped. If issueType is empty, the type of the
+// original is used. If subtasks is set, the subtasks of the issue are cloned
+// as well. It returns the key of the new issue.
+func CloneIssue(jc *Client, key, project, issueType string, subtasks bool) (string, error) {
+	issue, err := GetIssue(jc, key)
+	if err != nil {
+		return "", err
+	}
+	if issue.Fields == nil {
+		return "", errors.New("issue missing fields")
+	}
+
+	if project == "" {
+		project = issue.Fields.Project.Key
+	}
+	if issueType == "" {
+		issueType = issue.Fields.Type.Name
+	}
+
+	fields := map[string]interface{}{
+		"project":     map[string]string{"key": project},
+		"issuetype":   map[string]string{"name": issueType},
+		"summary":     issue.Fields.Summary,
+		"description": issue.Fields.Description,
+		"labels":      issue.Fields.Labels,
+	}
+
+	if len(issue.Fields.Components) > 0 {
+		available, err := GetComponentsForProject(jc, project)
+		if err != nil {
+			return "", err
+		}
+
+		var components []map[string]string
+		for _, c := range issue.Fields.Components {
+			for _, a := range available {
+				if a.Name == c.Name {
+					components = append(components, map[string]string{"name": c.Name})
+					break
+				}
+			}
+		}
+		fields["components"] = components
+	}
+
+	newKey, err := CreateIssueFromFields(jc, fields)
+	if err != nil {
+		return "", err
+	}
+
+	for _, l := range issue.Fields.IssueLinks {
+		var err error
+		switch {
+		case l.OutwardIssue != nil:
+			err = LinkIssues(jc, newKey, l.OutwardIssue.Key, l.Type.Name)
+		case l.InwardIssue != nil:
+			err = LinkIssues(jc, l.InwardIssue.Key, newKey, l.Type.Name)
+		}
+		if err != nil {
+			log.Printf("Could not copy link of %s to %s: %v", key, newKey, err)
+		}
+	}
+
+	if subtasks {
+		keys, err := GetAllKeysForSearch(jc, fmt.Sprintf("parent = %s", key))
+		if err != nil {
+			return newKey, err
+		}
+		for _, k := range keys {
+			if err := cloneSubtask(jc, k, project, newKey); err != nil {
+				return newKey, fmt.Errorf("could not clone subtask %s: %v", k, err)
+			}
+		}
+	}
+
+	return newKey, nil
+}
+
+func cloneSubtask(jc *Client, key, project, parent string) error {
+	issue, err := GetIssue(jc, key)
+	if err != nil {
+		return err
+	}
+	if issue.Fields == nil {
+		return errors.New("issue missing fields")
+	}
+
+	fields := map[string]interface{}{
+		"project":     map[string]string{"key": project},
+		"issuetype":   map[string]string{"name": issue.Fields.Type.Name},
+		"parent":      map[string]string{"key": parent},
+		"summary":     issue.Fields.Summary,
+		"description": issue.Fields.Description,
+		"labels":      issue.Fields.Labels,
+	}
+
+	_, err = CreateIssueFromFields(jc, fields)
+	return err
+}
+
+// MoveIssue moves the issue to another project. As the REST API does not
+// support changing the project of an issue, the issue is cloned into the
+// project along with its comments, brought to the status of the same name as
+// the original if the new workflow has one, and the original is archived to
+// the trash and deleted. If anything could not be carried over, the original
+// is kept and an error is returned along with the notes.
+// Subtasks, worklogs, attachments and history are not moved, so issues with
+// subtasks or worklogs are refused unless force is set. Changing only the
+// issue type is done in place. It returns the new key and notes about what
+// could not be carried over. Completed moves are listed in the moved file of
+// the issues folder.
+func MoveIssue(jc *Client, key, project, issueType string, force bool) (string, []string, error) {
+	issue, err := GetIssue(jc, key)
+	if err != nil {
+		return "", nil, err
+	}
+	if issue.Fields == nil || issue.Fields.Status == nil {
+		return "", nil, errors.New("issue missing status")
+	}
+	if strings.EqualFold(issue.Fields.Project.Key, project) {
+		if issueType == "" {
+			return "", nil, errors.New("issue already in project")
+		}
+		if err := SetFieldInIssue(jc, key, "type", issueType); err != nil {
+			return "", nil, err
+		}
+		return issue.Key, nil, nil
+	}
+
+	if !force {
+		subtasks, err := GetKeysForSearch(jc, fmt.Sprintf("parent = %s", key), 1)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(subtasks) > 0 {
+			return "", nil, errors.New("issue has subtasks, which would not be moved")
+		}
+
+		w, err := GetWorklogForIssue(jc, key)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(w.Worklogs) > 0 {
+			return "", nil, errors.New("issue has worklogs, which would not be moved")
+		}
+	}
+
+	newKey, err := CloneIssue(jc, key, project, issueType, false)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var notes []string
+	ids, err := GetCommentsForIssue(jc, key)
+	if err != nil {
+		notes = append(notes, fmt.Sprintf("comments not copied: %v", err))
+	}
+	for _, id := range ids {
+		c, err := GetComment(jc, key, id)
+		if err == nil {
+			body := fmt.Sprintf("[Comment by %s on %s, moved from %s]\n%s", c.Author.Name, c.Created, key, c.Body)
+			err = AddComment(jc, newKey, body)
+		}
+		if err != nil {
+			notes = append(notes, fmt.Sprintf("comment %s not copied: %v", id, err))
+		}
+	}
+
+	if err := ChangeStatus(jc, newKey, issue.Fields.Status.Name, nil, nil); err != nil {
+		notes = append(notes, fmt.Sprintf("status %s not remapped: %v", issue.Fields.Status.Name, err))
+	}
+
+	if len(notes) > 0 {
+		return newKey, notes, fmt.Errorf("%s not deleted, as it was not fully copied to %s", key, newKey)
+	}
+
+	if err := SafeDeleteIssue(jc, key); err != nil {
+		return newKey, notes, err
+	}
+
+	// The original issue is gone, so its ctl.result can no longer be read.
+	jc.results.Append("moved", fmt.Sprintf("%s %s\n", key, newKey))
+	return newKey, notes, nil
+}
+
+// reportMove records the outcome of a move. If the move failed, the original
+// issue is kept, and the outcome is left in its ctl.result file. Otherwise
+// the original is gone, so the outcome is left in the ctl.result file of the
+// new issue.
+func reportMove(jc *Client, key, newKey string, notes []string, err error) {
+	if err != nil || newKey == key {
+		res := newKey + "\n"
+		for _, n := range notes {
+			res += n + "\n"
+		}
+		if err != nil {
+			res += fmt.Sprintf("error: %v\n", err)
+		}
+		jc.results.Set(key+"/ctl.result", res)
+		return
+	}
+
+	res := fmt.Sprintf("moved from %s\n", key)
+	for _, n := range notes {
+		res += n + "\n"
+	}
+	jc.results.Set(newKey+"/ctl.result", res)
+	log.Printf("Moved %s to %s", key, newKey)
+}
+
+// RenameTargetProject returns the project to move an issue to when its folder
+// is renamed to newname, which is a project key optionally followed by a dash
+// and anything, such as "DEF", "DEF-" or "DEF-12".
+func RenameTargetProject(newname string) (string, error) {
+	project := strings.ToUpper(strings.SplitN(newname, "-", 2)[0])
+	if project == "" {
+		return "", errors.New("project missing")
+	}
+	for _, r := range project {
+		if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return "", fmt.Errorf("invalid project key: %s", project)
+		}
+	}
+	return project, nil
+}
+
+// RenameIssue moves the issue to the project named by newname, as described
+// by RenameTargetProject. Unlike the move command, renames are not confirmed,
+// as 9P clients have no way to do so; the original is archived to the trash
+// like every moved issue, and can be restored from there. The new key is
+// listed in the moved file, and the outcome is in the ctl.result file of the
+// new issue.
+func RenameIssue(jc *Client, key, newname string) error {
+	project, err := RenameTargetProject(newname)
+	if err != nil {
+		return err
+	}
+
+	newKey, notes, err := MoveIssue(jc, key, project, "", false)
+	reportMove(jc, key, newKey, notes, err)
+	return err
+}
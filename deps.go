@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type DepNode struct {
+	Key      string
+	Status   string
+	Resolved bool
+
+	// Expanded is false if the node was at the depth limit, in which case
+	// Blockers is not populated.
+	Expanded bool
+	Blockers []string
+}
+
+// DepGraph is the transitive closure of the issues blocking an issue through a
+// specific link type.
+type DepGraph struct {
+	root  string
+	nodes map[string]*DepNode
+}
+
+// BuildDepGraph fetches the issues blocking key through links named linkType,
+// following them at most depth levels.
+func BuildDepGraph(jc *Client, key, linkType string, depth int) (*DepGraph, error) {
+	dg := &DepGraph{
+		root:  key,
+		nodes: make(map[string]*DepNode),
+	}
+
+	level := []string{key}
+	for d := 0; len(level) > 0; d++ {
+		var next []string
+		for _, k := range level {
+			if _, exists := dg.nodes[k]; exists {
+				continue
+			}
+
+			issue, err := GetIssue(jc, k)
+			if err != nil {
+				return nil, err
+			}
+
+			n := &DepNode{Key: issue.Key}
+			dg.nodes[k] = n
+			if issue.Fields == nil {
+				continue
+			}
+			if issue.Fields.Status != nil {
+				n.Status = issue.Fields.Status.Name
+			}
+			n.Resolved = issue.Fields.Resolution != nil
+
+			if d >= depth {
+				continue
+			}
+			n.Expanded = true
+
+			// An inward issue on a link is the one doing the blocking.
+			for _, l := range issue.Fields.IssueLinks {
+				if l.InwardIssue == nil || !strings.EqualFold(l.Type.Name, linkType) {
+					continue
+				}
+				n.Blockers = append(n.Blockers, l.InwardIssue.Key)
+				next = append(next, l.InwardIssue.Key)
+			}
+		}
+		level = next
+	}
+
+	return dg, nil
+}
+
+func (dg *DepGraph) describe(n *DepNode) string {
+	s := fmt.Sprintf("%s [%s]", n.Key, n.Status)
+	if !n.Resolved && n.Key != dg.root {
+		s += " unresolved"
+	}
+	return s
+}
+
+// Tree renders the graph as an indented tree. Issues that block through a
+// cycle are marked as such, and issues already shown are not expanded again.
+func (dg *DepGraph) Tree() string {
+	var s string
+	shown := make(map[string]bool)
+	onPath := make(map[string]bool)
+
+	var walk func(key string, indent int)
+	walk = func(key string, indent int) {
+		n := dg.nodes[key]
+		prefix := strings.Repeat("	", indent)
+		switch {
+		case onPath[key]:
+			s += fmt.Sprintf("%s%s (cycle)\n", prefix, key)
+			return
+		case shown[key]:
+			s += fmt.Sprintf("%s%s (see above)\n", prefix, dg.describe(n))
+			return
+		}
+
+		s += prefix + dg.describe(n) + "\n"
+		shown[key] = true
+		if !n.Expanded {
+			if indent > 0 {
+				s += prefix + "	...\n"
+			}
+			return
+		}
+
+		onPath[key] = true
+		for _, b := range n.Blockers {
+			walk(b, indent+1)
+		}
+		onPath[key] = false
+	}
+
+	walk(dg.root, 0)
+	return s
+}
+
+// Dot renders the graph in the graphviz DOT format, with edges pointing from
+// the blocking issue to the blocked issue.
+func (dg *DepGraph) Dot() string {
+	s := "digraph deps {\n"
+	var edges string
+
+	var walk func(key string)
+	seen := make(map[string]bool)
+	walk = func(key string) {
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+
+		n := dg.nodes[key]
+		attrs := fmt.Sprintf("label=%q", n.Key+"\n"+n.Status)
+		if !n.Resolved && n.Key != dg.root {
+			attrs += ", color=red"
+		}
+		s += fmt.Sprintf("	%q [%s];\n", n.Key, attrs)
+
+		for _, b := range n.Blockers {
+			edges += fmt.Sprintf("	%q -> %q;\n", b, key)
+			walk(b)
+		}
+	}
+
+	walk(dg.root)
+	return s + edges + "}\n"
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joushou/qp"
+	"github.com/joushou/qptools/fileserver/trees"
+)
+
+const (
+	defaultEventInterval = 30 * time.Second
+
+	// eventBacklog is the number of events kept for readers that fall
+	// behind.
+	eventBacklog = 1000
+)
+
+type eventSearchResult struct {
+	Total  int `json:"total"`
+	Issues []struct {
+		Key    string `json:"key"`
+		Fields struct {
+			Created  string    `json:"created"`
+			Reporter *jiraUser `json:"reporter"`
+		} `json:"fields"`
+		Changelog struct {
+			Histories []struct {
+				ID      string   `json:"id"`
+				Author  jiraUser `json:"author"`
+				Created string   `json:"created"`
+				Items   []struct {
+					Field      string `json:"field"`
+					FromString string `json:"fromString"`
+					ToString   string `json:"toString"`
+				} `json:"items"`
+			} `json:"histories"`
+		} `json:"changelog"`
+	} `json:"issues"`
+}
+
+type event struct {
+	time time.Time
+	line string
+}
+
+func eventValue(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if s == "" {
+		return `""`
+	}
+	return s
+}
+
+// EventWatcher polls for changes to the issues matching a query while its
+// events file is open.
+type EventWatcher struct {
+	query string
+	ews   *EventWatchers
+
+	sync.Mutex
+	cond    *sync.Cond
+	events  []string
+	base    int
+	subs    int
+	stop    chan struct{}
+	started time.Time
+	last    time.Time
+
+	// seen holds the changelog entries already reported, with their time.
+	seen map[string]time.Time
+}
+
+// poll fetches the changes since the last poll, with a minute of margin as
+// JQL dates have minute precision. Changes made before the watcher started
+// are not reported.
+func (ew *EventWatcher) poll(jc *Client) error {
+	ew.Lock()
+	since, started := ew.last, ew.started
+	ew.Unlock()
+	now := time.Now()
+
+	when, err := jqlTime(jc, since.Add(-time.Minute))
+	if err != nil {
+		return fmt.Errorf("could not poll for events: %v", err)
+	}
+	query := fmt.Sprintf("updated >= \"%s\"", when)
+	if strings.TrimSpace(ew.query) != "" {
+		query = fmt.Sprintf("(%s) AND %s", ew.query, query)
+	}
+
+	var events []event
+	var fetched int
+	for {
+		var s eventSearchResult
+		u := fmt.Sprintf("/rest/api/2/search?fields=created,reporter&expand=changelog&startAt=%d&maxResults=50&jql=%s", fetched, url.QueryEscape(query))
+		if err := jc.RPC("GET", u, nil, &s); err != nil {
+			return fmt.Errorf("could not poll for events: %v", err)
+		}
+		fetched += len(s.Issues)
+
+		ew.Lock()
+		for _, issue := range s.Issues {
+			created, err := time.Parse(jiraTimeLayout, issue.Fields.Created)
+			if id := "created:" + issue.Key; err == nil && !created.Before(started) && ew.seen[id].IsZero() {
+				ew.seen[id] = created
+				var author string
+				if issue.Fields.Reporter != nil {
+					author = issue.Fields.Reporter.Name
+				}
+				events = append(events, event{created, fmt.Sprintf("%s created %s", issue.Key, author)})
+			}
+
+			for _, h := range issue.Changelog.Histories {
+				t, err := time.Parse(jiraTimeLayout, h.Created)
+				if err != nil || t.Before(started) || !ew.seen[h.ID].IsZero() {
+					continue
+				}
+				ew.seen[h.ID] = t
+				for _, item := range h.Items {
+					line := fmt.Sprintf("%s %s %s -> %s %s", issue.Key, strings.ToLower(item.Field),
+						eventValue(item.FromString), eventValue(item.ToString), h.Author.Name)
+					events = append(events, event{t, line})
+				}
+			}
+		}
+		ew.Unlock()
+
+		if len(s.Issues) == 0 || fetched >= s.Total {
+			break
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].time.Before(events[j].time)
+	})
+
+	ew.Lock()
+	defer ew.Unlock()
+	for _, e := range events {
+		ew.events = append(ew.events, e.line+"\n")
+	}
+	if len(ew.events) > eventBacklog {
+		drop := len(ew.events) - eventBacklog
+		ew.events = append([]string(nil), ew.events[drop:]...)
+		ew.base += drop
+	}
+	for id, t := range ew.seen {
+		if t.Before(since.Add(-5 * time.Minute)) {
+			delete(ew.seen, id)
+		}
+	}
+	ew.last = now
+	ew.cond.Broadcast()
+	return nil
+}
+
+// run polls until stopped. Changes to the event interval take effect after
+// the next poll.
+func (ew *EventWatcher) run(jc *Client, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(jc.EventInterval()):
+			if jc.Offline() {
+				continue
+			}
+			if err := ew.poll(jc); err != nil {
+				log.Printf("Could not poll events for %q: %v", ew.query, err)
+			}
+		}
+	}
+}
+
+// subscribe returns a subscription to new events, polling for them until
+// the last subscription is closed.
+func (ew *EventWatcher) subscribe(jc *Client) *eventSub {
+	ew.Lock()
+	defer ew.Unlock()
+
+	ew.subs++
+	if ew.subs == 1 {
+		ew.started = time.Now()
+		ew.last = ew.started
+		ew.seen = make(map[string]time.Time)
+		ew.stop = make(chan struct{})
+		go ew.run(jc.Background(), ew.stop)
+	}
+	return &eventSub{ew: ew, pos: ew.base + len(ew.events)}
+}
+
+type eventSub struct {
+	ew     *EventWatcher
+	pos    int
+	closed bool
+}
+
+// next blocks until there are new events, returning io.EOF once closed.
+func (es *eventSub) next() ([]byte, error) {
+	ew := es.ew
+	ew.Lock()
+	defer ew.Unlock()
+
+	for !es.closed && es.pos >= ew.base+len(ew.events) {
+		ew.cond.Wait()
+	}
+	if es.closed {
+		return nil, io.EOF
+	}
+
+	// Readers that fell behind the backlog skip ahead.
+	if es.pos < ew.base {
+		es.pos = ew.base
+	}
+
+	b := []byte(strings.Join(ew.events[es.pos-ew.base:], ""))
+	es.pos = ew.base + len(ew.events)
+	return b, nil
+}
+
+// close ends the subscription. The last close stops the watcher, and
+// removes it from its EventWatchers.
+func (es *eventSub) close() {
+	ew := es.ew
+	ew.ews.Lock()
+	defer ew.ews.Unlock()
+	ew.Lock()
+	defer ew.Unlock()
+
+	if es.closed {
+		return
+	}
+	es.closed = true
+	ew.subs--
+	if ew.subs == 0 {
+		close(ew.stop)
+		if ew.ews.watchers[ew.query] == ew {
+			delete(ew.ews.watchers, ew.query)
+		}
+	}
+	ew.cond.Broadcast()
+}
+
+// EventWatchers holds the event watchers by query, while they have
+// subscriptions. The zero value is ready to use.
+type EventWatchers struct {
+	sync.Mutex
+	watchers map[string]*EventWatcher
+}
+
+// Subscribe subscribes to the events of the query, starting a watcher for
+// it if there is none.
+func (ews *EventWatchers) Subscribe(jc *Client, query string) *eventSub {
+	ews.Lock()
+	defer ews.Unlock()
+	if ews.watchers == nil {
+		ews.watchers = make(map[string]*EventWatcher)
+	}
+
+	ew, exists := ews.watchers[query]
+	if !exists {
+		ew = &EventWatcher{query: query, ews: ews}
+		ew.cond = sync.NewCond(ew)
+		ews.watchers[query] = ew
+	}
+	return ew.subscribe(jc)
+}
+
+// EventFile is a read-only file where reads block until there are changes
+// to the issues matching a query, returning a line per change. Like tail,
+// every open only sees the changes from then on.
+type EventFile struct {
+	jc    *Client
+	query string
+	*trees.SyntheticFile
+}
+
+func (ef *EventFile) Open(user string, mode qp.OpenMode) (trees.ReadWriteAtCloser, error) {
+	if !ef.CanOpen(user, mode) || mode&3 != qp.OREAD {
+		return nil, trees.ErrPermissionDenied
+	}
+
+	return &eventHandle{sub: ef.jc.events.Subscribe(ef.jc, ef.query)}, nil
+}
+
+func NewEventFile(name string, jc *Client, query string) *EventFile {
+	return &EventFile{
+		jc:            jc,
+		query:         query,
+		SyntheticFile: trees.NewSyntheticFile(name, 0555, "jira", "jira"),
+	}
+}
+
+type eventHandle struct {
+	sync.Mutex
+	sub *eventSub
+
+	// buf holds the events from offset base that have not been read past
+	// yet.
+	buf  []byte
+	base int64
+}
+
+func (eh *eventHandle) ReadAt(p []byte, offset int64) (int, error) {
+	eh.Lock()
+	defer eh.Unlock()
+
+	if offset < eh.base {
+		return 0, errors.New("cannot seek backwards in event file")
+	}
+
+	for offset >= eh.base+int64(len(eh.buf)) {
+		b, err := eh.sub.next()
+		if err == io.EOF {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		eh.buf = append(eh.buf, b...)
+	}
+
+	start := offset - eh.base
+	n := copy(p, eh.buf[start:])
+
+	// Drop what has been read past, keeping the last read for retries.
+	eh.buf = eh.buf[start:]
+	eh.base = offset
+	return n, nil
+}
+
+func (eh *eventHandle) WriteAt(p []byte, offset int64) (int, error) {
+	return 0, errors.New("cannot write to event file")
+}
+
+// Close ends the subscription, waking up any blocked read.
+func (eh *eventHandle) Close() error {
+	eh.sub.close()
+	return nil
+}
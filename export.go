@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+const exportPageSize = 100
+
+// ExportFormats are the supported export formats, by file name.
+var ExportFormats = []string{"export.csv", "export.json", "export.jsonl"}
+
+// GetSearchPage fetches a page of issues matching the query, with the
+// requested fields.
+func GetSearchPage(jc *Client, query string, fields []string, startAt, max int) (*RawSearchResult, error) {
+	var s RawSearchResult
+	url := fmt.Sprintf("/rest/api/2/search?fields=%s&startAt=%d&maxResults=%d&jql=%s",
+		url.QueryEscape(strings.Join(fields, ",")), startAt, max, url.QueryEscape(query))
+	if err := jc.RPC("GET", url, nil, &s); err != nil {
+		return nil, fmt.Errorf("could not execute search: %v", err)
+	}
+	return &s, nil
+}
+
+// ExportStream returns a generator for a StreamFile, rendering all issues
+// matching the query in the format of the named export file. Issues are
+// fetched a page at a time as the file is read.
+func ExportStream(jc *Client, file, query string, columns []string) func() ([]byte, error) {
+	fields := ColumnFields(columns)
+	startAt := 0
+	started, finished := false, false
+
+	renderJSON := func(key string, values map[string]json.RawMessage) ([]byte, error) {
+		obj := make(map[string]interface{})
+		for i, f := range fields {
+			if f == "key" {
+				obj[columns[i]] = key
+				continue
+			}
+			if v, exists := values[f]; exists {
+				obj[columns[i]] = v
+			} else {
+				obj[columns[i]] = nil
+			}
+		}
+		return json.Marshal(obj)
+	}
+
+	return func() ([]byte, error) {
+		if finished {
+			return nil, io.EOF
+		}
+
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+
+		if !started {
+			started = true
+			switch file {
+			case "export.csv":
+				w.Write(columns)
+			case "export.json":
+				buf.WriteString("[\n")
+			}
+		}
+
+		s, err := GetSearchPage(jc, query, fields, startAt, exportPageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, issue := range s.Issues {
+			switch file {
+			case "export.csv":
+				row := make([]string, len(fields))
+				for i, f := range fields {
+					if f == "key" {
+						row[i] = issue.Key
+					} else {
+						row[i] = RenderFieldValue(issue.Fields[f])
+					}
+				}
+				w.Write(row)
+			case "export.json", "export.jsonl":
+				b, err := renderJSON(issue.Key, issue.Fields)
+				if err != nil {
+					return nil, err
+				}
+				if file == "export.json" && startAt > 0 {
+					buf.WriteString(",\n")
+				}
+				buf.Write(b)
+				if file == "export.jsonl" {
+					buf.WriteString("\n")
+				}
+			}
+			startAt++
+		}
+
+		if len(s.Issues) == 0 || startAt >= s.Total {
+			finished = true
+			if file == "export.json" {
+				buf.WriteString("\n]\n")
+			}
+		}
+
+		w.Flush()
+		return buf.Bytes(), w.Error()
+	}
+}
+
+// exportFile returns a stream file exporting the issues matching the query,
+// using the columns configured for the listing identified by id.
+func exportFile(jc *Client, file, id, query string) *StreamFile {
+	return NewStreamFile(file, 0555, "jira", "jira", func() func() ([]byte, error) {
+		return ExportStream(jc, file, query, listingColumns(jc, id))
+	})
+}
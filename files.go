@@ -3,8 +3,11 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/joushou/qp"
@@ -23,17 +26,58 @@ type jiraRemover interface {
 	Remove(jc *Client, name string) error
 }
 
+type jiraCreator interface {
+	Create(jc *Client, name string, perms qp.FileMode) (trees.File, error)
+}
+
+type jiraRenamer interface {
+	Rename(jc *Client, oldname, newname string) error
+}
+
 // JiraDir is a convenience wrapper for dynamic directory hooks.
 type JiraDir struct {
 	thing  interface{}
 	client *Client
+	path   string
 	*trees.SyntheticDir
 }
 
+func (jd *JiraDir) child(name string) string {
+	return jd.path + "/" + name
+}
+
+// place tells files walked to from the directory where they are and which
+// client they belong to, for the audit log.
+func (jd *JiraDir) place(f trees.File, name string) {
+	switch x := f.(type) {
+	case *JiraDir:
+		if x != nil {
+			x.path = jd.child(name)
+		}
+	case *CloseSaver:
+		if x != nil {
+			x.path = jd.child(name)
+			x.jc = jd.client
+		}
+	case *CommandFile:
+		if x != nil {
+			x.path = jd.child(name)
+			x.jc = jd.client
+		}
+	}
+}
+
 func (jd *JiraDir) Walk(user, name string) (trees.File, error) {
+	start := time.Now()
+	f, err := jd.walk(user, name)
+	jd.client.audit.Op(user, jd.child(name), "walk", start, err)
+	jd.place(f, name)
+	return f, err
+}
 
+func (jd *JiraDir) walk(user, name string) (trees.File, error) {
 	if f, ok := jd.thing.(jiraWalker); ok {
-		return f.Walk(jd.client, name)
+		return f.Walk(jd.client.WithScope(user, jd.child(name)), name)
 	}
 	if f, ok := jd.thing.(trees.Dir); ok {
 		return f.Walk(user, name)
@@ -43,8 +87,15 @@ func (jd *JiraDir) Walk(user, name string) (trees.File, error) {
 }
 
 func (jd *JiraDir) List(user string) ([]qp.Stat, error) {
+	start := time.Now()
+	s, err := jd.list(user)
+	jd.client.audit.Op(user, jd.path+"/", "list", start, err)
+	return s, err
+}
+
+func (jd *JiraDir) list(user string) ([]qp.Stat, error) {
 	if f, ok := jd.thing.(jiraLister); ok {
-		return f.List(jd.client)
+		return f.List(jd.client.WithScope(user, jd.path+"/"))
 	}
 	if f, ok := jd.thing.(trees.Lister); ok {
 		return f.List(user)
@@ -54,8 +105,15 @@ func (jd *JiraDir) List(user string) ([]qp.Stat, error) {
 }
 
 func (jd *JiraDir) Remove(user, name string) error {
+	start := time.Now()
+	err := jd.remove(user, name)
+	jd.client.audit.Op(user, jd.child(name), "remove", start, err)
+	return err
+}
+
+func (jd *JiraDir) remove(user, name string) error {
 	if f, ok := jd.thing.(jiraRemover); ok {
-		return f.Remove(jd.client, name)
+		return f.Remove(jd.client.WithScope(user, jd.child(name)), name)
 	}
 	if f, ok := jd.thing.(trees.Dir); ok {
 		return f.Remove(user, name)
@@ -64,8 +122,30 @@ func (jd *JiraDir) Remove(user, name string) error {
 	return trees.ErrPermissionDenied
 }
 
+func (jd *JiraDir) Rename(user, oldname, newname string) error {
+	start := time.Now()
+	err := trees.ErrPermissionDenied
+	if f, ok := jd.thing.(jiraRenamer); ok {
+		err = f.Rename(jd.client.WithScope(user, jd.child(oldname)), oldname, newname)
+	}
+
+	e := auditEntry("9p", start, err)
+	e.User, e.Path, e.Op, e.Target = user, jd.child(oldname), "rename", jd.child(newname)
+	jd.client.audit.Record(e)
+	return err
+}
+
 func (jd *JiraDir) Create(user, name string, perms qp.FileMode) (trees.File, error) {
-	return nil, trees.ErrPermissionDenied
+	start := time.Now()
+	var f trees.File
+	err := trees.ErrPermissionDenied
+	if c, ok := jd.thing.(jiraCreator); ok {
+		f, err = c.Create(jd.client.WithScope(user, jd.child(name)), name, perms)
+	}
+
+	jd.client.audit.Op(user, jd.child(name), "create", start, err)
+	jd.place(f, name)
+	return f, err
 }
 
 func (jd *JiraDir) Open(user string, mode qp.OpenMode) (trees.ReadWriteAtCloser, error) {
@@ -77,15 +157,15 @@ func (jd *JiraDir) Open(user string, mode qp.OpenMode) (trees.ReadWriteAtCloser,
 	defer jd.Unlock()
 	jd.Atime = time.Now()
 	jd.Opens++
-	return &trees.ListHandle{
+	return newCountedHandle(&jd.client.metrics, &trees.ListHandle{
 		Dir:  jd,
 		User: user,
-	}, nil
+	}), nil
 }
 
 func NewJiraDir(name string, perm qp.FileMode, user, group string, jc *Client, thing interface{}) (*JiraDir, error) {
 	switch thing.(type) {
-	case trees.Dir, jiraWalker, jiraLister, jiraRemover:
+	case trees.Dir, jiraWalker, jiraLister, jiraRemover, jiraCreator, jiraRenamer:
 	default:
 		return nil, fmt.Errorf("unsupported type: %T", thing)
 	}
@@ -99,6 +179,8 @@ func NewJiraDir(name string, perm qp.FileMode, user, group string, jc *Client, t
 
 type CloseSaverHandle struct {
 	onClose func() error
+	user    string
+	cs      *CloseSaver
 	trees.ReadWriteAtCloser
 }
 
@@ -108,17 +190,32 @@ func (csh *CloseSaverHandle) Close() error {
 		return err
 	}
 
-	if csh.onClose != nil {
-		return csh.onClose()
+	if csh.onClose == nil {
+		return nil
 	}
 
-	return nil
+	start := time.Now()
+	err = csh.onClose()
+	if jc := csh.cs.jc; jc != nil {
+		jc.audit.Op(csh.user, csh.cs.path, "write", start, err)
+		if err != nil && !IsQueued(err) {
+			jc.metrics.FailedWrite(path.Base(csh.cs.path))
+		}
+	}
+
+	// Queued writes are applied once JIRA can be reached again.
+	if IsQueued(err) {
+		return nil
+	}
+	return err
 }
 
 // CloseSaver calls a callback on save if the file was opened for writing.
 type CloseSaver struct {
 	onClose    func() error
 	forceTrunc bool
+	jc         *Client
+	path       string
 	trees.File
 }
 
@@ -138,10 +235,16 @@ func (cs *CloseSaver) Open(user string, mode qp.OpenMode) (trees.ReadWriteAtClos
 		return nil, err
 	}
 
-	return &CloseSaverHandle{
+	csh := &CloseSaverHandle{
 		ReadWriteAtCloser: hndl,
 		onClose:           closer,
-	}, nil
+		user:              user,
+		cs:                cs,
+	}
+	if cs.jc == nil {
+		return csh, nil
+	}
+	return newCountedHandle(&cs.jc.metrics, csh), nil
 }
 
 func NewCloseSaver(file trees.File, onClose func() error) *CloseSaver {
@@ -154,6 +257,8 @@ func NewCloseSaver(file trees.File, onClose func() error) *CloseSaver {
 // CommandFile calls commands on write.
 type CommandFile struct {
 	cmds map[string]func([]string) error
+	jc   *Client
+	path string
 	*trees.SyntheticFile
 }
 
@@ -163,18 +268,34 @@ func (cf *CommandFile) ReadAt(p []byte, offset int64) (int, error) {
 }
 
 func (cf *CommandFile) WriteAt(p []byte, offset int64) (int, error) {
+	return cf.write("", p)
+}
+
+func (cf *CommandFile) write(user string, p []byte) (int, error) {
 	args := strings.Split(strings.Trim(string(p), " \n"), " ")
 	cmd := args[0]
 	args = args[1:]
 
+	start := time.Now()
+	err := errors.New("no such command")
 	if f, exists := cf.cmds[cmd]; exists {
-		err := f(args)
+		err = f(args)
 		if err != nil {
 			log.Printf("Command %s failed: %v", cmd, err)
 		}
-		return len(p), err
 	}
-	return len(p), errors.New("no such command")
+
+	if cf.jc != nil {
+		// Only the command name is recorded, as arguments may contain
+		// credentials.
+		e := auditEntry("9p", start, err)
+		e.User, e.Path, e.Op, e.Command = user, cf.path, "command", cmd
+		cf.jc.audit.Record(e)
+	}
+	if IsQueued(err) {
+		return len(p), nil
+	}
+	return len(p), err
 }
 
 func (cf *CommandFile) Open(user string, mode qp.OpenMode) (trees.ReadWriteAtCloser, error) {
@@ -182,7 +303,21 @@ func (cf *CommandFile) Open(user string, mode qp.OpenMode) (trees.ReadWriteAtClo
 		return nil, trees.ErrPermissionDenied
 	}
 
-	return cf, nil
+	ch := &commandHandle{CommandFile: cf, user: user}
+	if cf.jc == nil {
+		return ch, nil
+	}
+	return newCountedHandle(&cf.jc.metrics, ch), nil
+}
+
+// commandHandle remembers the user that opened a command file.
+type commandHandle struct {
+	*CommandFile
+	user string
+}
+
+func (ch *commandHandle) WriteAt(p []byte, offset int64) (int, error) {
+	return ch.write(ch.user, p)
 }
 
 func NewCommandFile(name string, perms qp.FileMode, user, group string, cmds map[string]func([]string) error) *CommandFile {
@@ -191,3 +326,110 @@ func NewCommandFile(name string, perms qp.FileMode, user, group string, cmds map
 		SyntheticFile: trees.NewSyntheticFile(name, perms, user, group),
 	}
 }
+
+// ResultStore keeps the output of files that are written and then read back,
+// such as plans and command results. As file views are recreated on every
+// walk, the results are kept by name across walks.
+type ResultStore struct {
+	sync.Mutex
+	results map[string]string
+}
+
+func (rs *ResultStore) Set(name, result string) {
+	rs.Lock()
+	defer rs.Unlock()
+	if rs.results == nil {
+		rs.results = make(map[string]string)
+	}
+	rs.results[name] = result
+}
+
+// Append adds to an existing result.
+func (rs *ResultStore) Append(name, result string) {
+	rs.Lock()
+	defer rs.Unlock()
+	if rs.results == nil {
+		rs.results = make(map[string]string)
+	}
+	rs.results[name] += result
+}
+
+func (rs *ResultStore) Get(name string) string {
+	rs.Lock()
+	defer rs.Unlock()
+	return rs.results[name]
+}
+
+// StreamFile is a read-only file whose content is generated in chunks while
+// it is being read, allowing large content to be served without producing it
+// all up front. Every open starts a new stream from the generator returned by
+// open, which returns io.EOF when done. Streams can only be read sequentially.
+type StreamFile struct {
+	open func() func() ([]byte, error)
+	*trees.SyntheticFile
+}
+
+func (sf *StreamFile) Open(user string, mode qp.OpenMode) (trees.ReadWriteAtCloser, error) {
+	if !sf.CanOpen(user, mode) || mode&3 != qp.OREAD {
+		return nil, trees.ErrPermissionDenied
+	}
+
+	return &streamHandle{next: sf.open()}, nil
+}
+
+func NewStreamFile(name string, perms qp.FileMode, user, group string, open func() func() ([]byte, error)) *StreamFile {
+	return &StreamFile{
+		open:          open,
+		SyntheticFile: trees.NewSyntheticFile(name, perms, user, group),
+	}
+}
+
+type streamHandle struct {
+	sync.Mutex
+	next func() ([]byte, error)
+
+	// buf holds the generated content from offset base that has not been
+	// read past yet.
+	buf  []byte
+	base int64
+	done bool
+}
+
+func (sh *streamHandle) ReadAt(p []byte, offset int64) (int, error) {
+	sh.Lock()
+	defer sh.Unlock()
+
+	if offset < sh.base {
+		return 0, errors.New("cannot seek backwards in stream")
+	}
+
+	for !sh.done && offset+int64(len(p)) > sh.base+int64(len(sh.buf)) {
+		chunk, err := sh.next()
+		if err == io.EOF {
+			sh.done = true
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		sh.buf = append(sh.buf, chunk...)
+	}
+
+	start := offset - sh.base
+	if start >= int64(len(sh.buf)) {
+		return 0, nil
+	}
+
+	n := copy(p, sh.buf[start:])
+
+	// Drop what has been read past, keeping the last read for retries.
+	sh.buf = sh.buf[start:]
+	sh.base = offset
+	return n, nil
+}
+
+func (sh *streamHandle) WriteAt(p []byte, offset int64) (int, error) {
+	return 0, errors.New("cannot write to stream file")
+}
+
+func (sh *streamHandle) Close() error { return nil }
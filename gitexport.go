@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/joushou/qp"
+	"github.com/joushou/qptools/fileserver/trees"
+)
+
+// gitExportFiles are the files of an issue folder written by git exports.
+// Files that are commands, or that are derived from other issues or the
+// workflow, are left out.
+var gitExportFiles = []string{"assignee", "creator", "description", "type", "key", "reporter", "status",
+	"summary", "labels", "priority", "resolution", "links", "components", "project", "fixversions",
+	"affectsversions"}
+
+var gitExportCommentFiles = []string{"author", "comment", "created", "updated"}
+
+// gitChange is the author and time of the latest change to an issue.
+type gitChange struct {
+	Name  string
+	Email string
+	Time  time.Time
+}
+
+type jiraUser struct {
+	Name         string `json:"name"`
+	DisplayName  string `json:"displayName"`
+	EmailAddress string `json:"emailAddress"`
+}
+
+// gitCommitter is credited with changes of unknown author.
+var gitCommitter = &jiraUser{Name: "jirafs", EmailAddress: "jirafs@localhost"}
+
+type gitChangelog struct {
+	Fields struct {
+		Created  string    `json:"created"`
+		Updated  string    `json:"updated"`
+		Reporter *jiraUser `json:"reporter"`
+	} `json:"fields"`
+	Changelog struct {
+		Histories []struct {
+			Author  jiraUser `json:"author"`
+			Created string   `json:"created"`
+		} `json:"histories"`
+	} `json:"changelog"`
+}
+
+func (gc *gitChange) update(u *jiraUser, t string) {
+	tm, err := time.Parse(jiraTimeLayout, t)
+	if err != nil || u == nil || !tm.After(gc.Time) {
+		return
+	}
+	gc.Time = tm
+	gc.Name = u.DisplayName
+	if gc.Name == "" {
+		gc.Name = u.Name
+	}
+	gc.Email = u.EmailAddress
+}
+
+// readTreeFile reads the entire content of a file.
+func readTreeFile(f trees.File) ([]byte, error) {
+	h, err := f.Open("jira", qp.OREAD)
+	if err != nil {
+		return nil, err
+	}
+	defer h.Close()
+
+	var b []byte
+	buf := make([]byte, 8192)
+	for {
+		n, err := h.ReadAt(buf, int64(len(b)))
+		b = append(b, buf[:n]...)
+		if err == io.EOF || (err == nil && n == 0) {
+			return b, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+type gitIssue struct {
+	key     string
+	summary string
+	files   map[string][]byte
+	change  gitChange
+}
+
+// renderGitIssue renders the issue in the layout of its issue folder, by
+// reading the files of the issue view.
+func renderGitIssue(jc *Client, key string) (*gitIssue, error) {
+	var raw json.RawMessage
+	u := fmt.Sprintf("/rest/api/2/issue/%s?expand=changelog", key)
+	if err := jc.RPC("GET", u, nil, &raw); err != nil {
+		return nil, fmt.Errorf("could not query issue: %v", err)
+	}
+
+	var issue jira.Issue
+	if err := json.Unmarshal(raw, &issue); err != nil {
+		return nil, err
+	}
+	var cl gitChangelog
+	if err := json.Unmarshal(raw, &cl); err != nil {
+		return nil, err
+	}
+
+	gi := &gitIssue{key: issue.Key, files: make(map[string][]byte)}
+	if issue.Fields != nil {
+		gi.summary = issue.Fields.Summary
+	}
+	gi.change.update(cl.Fields.Reporter, cl.Fields.Created)
+	for _, h := range cl.Changelog.Histories {
+		gi.change.update(&h.Author, h.Created)
+	}
+
+	iw := &IssueView{issueNo: issue.Key, issue: &issue}
+	for _, file := range gitExportFiles {
+		f, err := iw.Walk(jc, file)
+		if err != nil {
+			return nil, err
+		}
+		if f == nil {
+			continue
+		}
+		b, err := readTreeFile(f)
+		if err != nil {
+			return nil, err
+		}
+		gi.files[file] = b
+	}
+
+	var cr CommentResult
+	u = fmt.Sprintf("/rest/api/2/issue/%s/comment?maxResults=1000", issue.Key)
+	if err := jc.RPC("GET", u, nil, &cr); err != nil {
+		return nil, fmt.Errorf("could not get comments: %v", err)
+	}
+	for i := range cr.Comments {
+		cmt := &cr.Comments[i]
+		for _, file := range gitExportCommentFiles {
+			gi.files[filepath.Join("comments", cmt.ID, file)] = commentContent(cmt, file)
+		}
+		gi.change.update(&jiraUser{Name: cmt.Author.Name, DisplayName: cmt.Author.DisplayName, EmailAddress: cmt.Author.EmailAddress}, cmt.Updated)
+	}
+
+	// Updates without a history entry or comment, such as to worklogs or
+	// attachments, have no known author.
+	gi.change.update(gitCommitter, cl.Fields.Updated)
+
+	return gi, nil
+}
+
+func runGit(dir string, env []string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	return out.String(), err
+}
+
+// write replaces the issue folder in the repository with the rendered issue.
+func (gi *gitIssue) write(repo string) error {
+	dir := filepath.Join(repo, gi.key)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	for name, b := range gi.files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(file, b, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commit commits the issue folder, if changed, with the author and time of
+// the latest change to the issue. It returns whether anything was committed.
+func (gi *gitIssue) commit(repo string) (bool, error) {
+	if out, err := runGit(repo, nil, "add", "-A", "--", gi.key); err != nil {
+		return false, fmt.Errorf("git add failed: %v: %s", err, out)
+	}
+
+	// diff --quiet exits with 1 if there are changes.
+	if _, err := runGit(repo, nil, "diff", "--cached", "--quiet", "--", gi.key); err == nil {
+		return false, nil
+	}
+
+	name := gi.change.Name
+	email := gi.change.Email
+	if name == "" {
+		name, email = gitCommitter.Name, gitCommitter.EmailAddress
+	}
+	when := gi.change.Time
+	if when.IsZero() {
+		when = time.Now()
+	}
+
+	env := []string{
+		"GIT_COMMITTER_NAME=" + gitCommitter.Name,
+		"GIT_COMMITTER_EMAIL=" + gitCommitter.EmailAddress,
+	}
+	msg := fmt.Sprintf("%s: %s", gi.key, gi.summary)
+	author := fmt.Sprintf("%s <%s>", name, email)
+	out, err := runGit(repo, env, "commit", "-q", "--author", author, "--date", when.Format(time.RFC3339), "-m", msg, "--", gi.key)
+	if err != nil {
+		return false, fmt.Errorf("git commit failed: %v: %s", err, out)
+	}
+	return true, nil
+}
+
+// GitExport writes the issues into the git repository given by the -git
+// flag, in the layout of their issue folders, committing each changed issue
+// with the author and time of its latest change. Commits are made in the
+// order of the changes. Changes of unknown author are credited to jirafs.
+// Exports are serialized. It returns a report with a line per issue.
+func GitExport(jc *Client, keys []string) (string, error) {
+	repo := jc.gitDir
+	if repo == "" {
+		return "", fmt.Errorf("no git repository configured, use the -git flag")
+	}
+
+	jc.gitLock.Lock()
+	defer jc.gitLock.Unlock()
+
+	if _, err := os.Stat(filepath.Join(repo, ".git")); os.IsNotExist(err) {
+		if err := os.MkdirAll(repo, 0755); err != nil {
+			return "", err
+		}
+		if out, err := runGit(repo, nil, "init", "-q"); err != nil {
+			return "", fmt.Errorf("git init failed: %v: %s", err, out)
+		}
+	}
+
+	results := make(map[string]string)
+	var issues []*gitIssue
+	for _, k := range keys {
+		gi, err := renderGitIssue(jc, k)
+		if err != nil {
+			results[k] = fmt.Sprintf("error: %v", err)
+			continue
+		}
+		issues = append(issues, gi)
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].change.Time.Before(issues[j].change.Time)
+	})
+
+	var failed int
+	for _, gi := range issues {
+		if err := gi.write(repo); err != nil {
+			results[gi.key] = fmt.Sprintf("error: %v", err)
+			continue
+		}
+		committed, err := gi.commit(repo)
+		switch {
+		case err != nil:
+			results[gi.key] = fmt.Sprintf("error: %v", err)
+		case committed:
+			results[gi.key] = "committed"
+		default:
+			results[gi.key] = "unchanged"
+		}
+	}
+
+	var res string
+	for _, k := range keys {
+		if strings.HasPrefix(results[k], "error") {
+			failed++
+		}
+		res += fmt.Sprintf("%s %s\n", k, results[k])
+	}
+
+	if failed > 0 {
+		return res, fmt.Errorf("%d of %d issues failed", failed, len(keys))
+	}
+	return res, nil
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// importBatchSize is the max number of issues created per bulk request.
+const importBatchSize = 50
+
+// ImportRow is a single row of an import, with the values by lower-cased
+// column name.
+type ImportRow map[string]string
+
+// ParseImport parses import data, which is either CSV with a header row naming
+// the columns, or JSON lines with an object per row. JSON values that are not
+// strings are rendered like index values, so arrays become comma separated
+// lists.
+func ParseImport(b []byte) ([]ImportRow, error) {
+	var rows []ImportRow
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		for i, line := range bytes.Split(b, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+
+			var obj map[string]json.RawMessage
+			if err := json.Unmarshal(line, &obj); err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+1, err)
+			}
+
+			row := make(ImportRow)
+			for k, v := range obj {
+				row[strings.ToLower(k)] = RenderFieldValue(v)
+			}
+			rows = append(rows, row)
+		}
+		return rows, nil
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("header row missing")
+	}
+
+	header := records[0]
+	for _, rec := range records[1:] {
+		row := make(ImportRow)
+		for i, v := range rec {
+			if i < len(header) {
+				row[strings.ToLower(strings.TrimSpace(header[i]))] = v
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// rowRef parses a "#N" reference to another row of the import, returning the
+// index of the row.
+func rowRef(s string) (int, bool) {
+	if !strings.HasPrefix(s, "#") {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s[1:])
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return n - 1, true
+}
+
+type importLink struct {
+	relation string
+	target   string
+}
+
+type importItem struct {
+	fields map[string]interface{}
+	parent string
+	links  []importLink
+
+	key   string
+	err   error
+	notes []string
+}
+
+// buildImportItem validates a row against the create metadata, and builds the
+// fields of the issue.
+func buildImportItem(project string, meta []CreateMetaIssueType, row ImportRow) *importItem {
+	item := &importItem{fields: make(map[string]interface{})}
+
+	typeName := row["type"]
+	if typeName == "" {
+		typeName = row["issuetype"]
+	}
+	var it *CreateMetaIssueType
+	for i := range meta {
+		if strings.EqualFold(meta[i].Name, typeName) {
+			it = &meta[i]
+		}
+	}
+	if it == nil {
+		item.err = fmt.Errorf("invalid issue type %q", typeName)
+		return item
+	}
+
+	item.fields["project"] = map[string]string{"key": project}
+	item.fields["issuetype"] = map[string]string{"id": it.ID}
+
+	for col, val := range row {
+		if val == "" {
+			continue
+		}
+
+		switch col {
+		case "type", "issuetype":
+			continue
+		case "project":
+			if !strings.EqualFold(val, project) {
+				item.err = fmt.Errorf("project %s does not match %s", val, project)
+				return item
+			}
+			continue
+		case "parent":
+			item.parent = strings.ToUpper(val)
+			continue
+		case "links":
+			for _, l := range strings.Split(val, ",") {
+				idx := strings.LastIndex(l, ":")
+				if idx <= 0 {
+					item.err = fmt.Errorf("invalid link %q, expected Relation:KEY", l)
+					return item
+				}
+				item.links = append(item.links, importLink{
+					relation: strings.TrimSpace(l[:idx]),
+					target:   strings.ToUpper(strings.TrimSpace(l[idx+1:])),
+				})
+			}
+			continue
+		}
+
+		id := col
+		if f, exists := indexFieldAliases[col]; exists {
+			id = f
+		}
+
+		var found bool
+		for fid, f := range it.Fields {
+			if !strings.EqualFold(fid, id) && !strings.EqualFold(f.Name, col) {
+				continue
+			}
+			found = true
+
+			if err := checkAllowedValues(f, val); err != nil {
+				item.err = fmt.Errorf("%s: %v", col, err)
+				return item
+			}
+
+			v, err := fieldValue(f, val)
+			if err != nil {
+				item.err = fmt.Errorf("%s: %v", col, err)
+				return item
+			}
+			item.fields[fid] = v
+			break
+		}
+		if !found {
+			item.err = fmt.Errorf("field %s is not on the create screen of %s", col, it.Name)
+			return item
+		}
+	}
+
+	for fid, f := range it.Fields {
+		if _, exists := item.fields[fid]; exists || fid == "parent" && item.parent != "" {
+			continue
+		}
+		if f.Required && !f.HasDefaultValue {
+			item.err = fmt.Errorf("required field %s missing", fid)
+			return item
+		}
+	}
+
+	return item
+}
+
+// checkAllowedValues checks the values against the allowed values of the
+// field, if it has any.
+func checkAllowedValues(f FieldMeta, val string) error {
+	if len(f.AllowedValues) == 0 {
+		return nil
+	}
+
+	vals := []string{val}
+	if f.Schema.Type == "array" {
+		vals = strings.Split(val, ",")
+	}
+
+	for _, v := range vals {
+		v = strings.TrimSpace(v)
+		var ok bool
+		for _, av := range f.AllowedValues {
+			if strings.EqualFold(v, av.Name) || strings.EqualFold(v, av.Value) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%q is not an allowed value", v)
+		}
+	}
+	return nil
+}
+
+// ImportIssues validates the rows against the create metadata of the project,
+// and creates the issues in bulk. Parents and link targets can refer to other
+// rows as "#N", where N is the 1-based row number, in which case the rows are
+// created in dependency order. The returned report maps every row to the
+// created key or an error.
+func ImportIssues(jc *Client, project string, rows []ImportRow) (string, error) {
+	meta, err := GetCreateMeta(jc, project)
+	if err != nil {
+		return "", err
+	}
+
+	items := make([]*importItem, len(rows))
+	for i, row := range rows {
+		items[i] = buildImportItem(project, meta, row)
+	}
+
+	// Create the issues in passes, as issues can only be created once their
+	// parent row has been created.
+	done := make([]bool, len(items))
+	for {
+		var batch []int
+		progress := false
+		for i, item := range items {
+			if done[i] {
+				continue
+			}
+			if item.err != nil {
+				done[i] = true
+				progress = true
+				continue
+			}
+
+			if n, ok := rowRef(item.parent); ok {
+				if n >= len(items) || n == i {
+					item.err = fmt.Errorf("invalid parent row %s", item.parent)
+					done[i] = true
+					progress = true
+					continue
+				}
+				if !done[n] {
+					continue
+				}
+				if items[n].key == "" {
+					item.err = fmt.Errorf("parent row %s failed", item.parent)
+					done[i] = true
+					progress = true
+					continue
+				}
+				item.fields["parent"] = map[string]string{"key": items[n].key}
+			} else if item.parent != "" {
+				item.fields["parent"] = map[string]string{"key": item.parent}
+			}
+
+			batch = append(batch, i)
+		}
+
+		if len(batch) == 0 {
+			if progress {
+				continue
+			}
+			break
+		}
+
+		for len(batch) > 0 {
+			n := len(batch)
+			if n > importBatchSize {
+				n = importBatchSize
+			}
+
+			var issues []map[string]interface{}
+			for _, i := range batch[:n] {
+				issues = append(issues, items[i].fields)
+			}
+
+			keys, errs, err := CreateIssues(jc, issues)
+			for j, i := range batch[:n] {
+				done[i] = true
+				switch {
+				case err != nil:
+					items[i].err = err
+				case errs[j] != nil:
+					items[i].err = errs[j]
+				default:
+					items[i].key = keys[j]
+				}
+			}
+			batch = batch[n:]
+		}
+	}
+
+	// Rows with parents that could never be created, such as rows that are
+	// each other's parents, are left over.
+	for i, item := range items {
+		if !done[i] {
+			item.err = fmt.Errorf("parent row %s was never created", item.parent)
+		}
+	}
+
+	for _, item := range items {
+		if item.key == "" {
+			continue
+		}
+		for _, l := range item.links {
+			target := l.target
+			if n, ok := rowRef(target); ok {
+				if n >= len(items) || items[n].key == "" {
+					item.notes = append(item.notes, fmt.Sprintf("link %s:%s skipped, row not created", l.relation, l.target))
+					continue
+				}
+				target = items[n].key
+			}
+			if err := LinkIssues(jc, item.key, target, l.relation); err != nil {
+				item.notes = append(item.notes, fmt.Sprintf("link %s:%s failed: %v", l.relation, l.target, err))
+			}
+		}
+	}
+
+	var s string
+	for i, item := range items {
+		if item.err != nil {
+			s += fmt.Sprintf("%d\terror: %v\n", i+1, item.err)
+			continue
+		}
+		s += fmt.Sprintf("%d\t%s", i+1, item.key)
+		for _, n := range item.notes {
+			s += "\t" + n
+		}
+		s += "\n"
+	}
+	return s, nil
+}
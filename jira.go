@@ -91,6 +91,22 @@ type CommentView struct {
 	comment string
 }
 
+// commentContent returns the content of a file of a comment folder.
+func commentContent(cmt *jira.Comment, file string) []byte {
+	switch file {
+	case "author":
+		return []byte(cmt.Author.Name + "\n")
+	case "comment":
+		return []byte(cmt.Body)
+	case "updated":
+		return []byte(cmt.Updated + "\n")
+	case "created":
+		return []byte(cmt.Created + "\n")
+	default:
+		return nil
+	}
+}
+
 func (cw *CommentView) Walk(jc *Client, file string) (trees.File, error) {
 	if !StringExistsInSets(file, []string{"author", "comment", "updated", "created"}) {
 		return nil, nil
@@ -101,21 +117,10 @@ func (cw *CommentView) Walk(jc *Client, file string) (trees.File, error) {
 		return nil, err
 	}
 
-	var cnt []byte
-	writable := false
-	forceTrunc := true
-	switch file {
-	case "author":
-		cnt = []byte(cmt.Author.Name + "\n")
-	case "comment":
-		cnt = []byte(cmt.Body)
-		forceTrunc = false
-		writable = true
-	case "updated":
-		cnt = []byte(cmt.Updated + "\n")
-	case "created":
-		cnt = []byte(cmt.Created + "\n")
-	}
+	cnt := commentContent(cmt, file)
+	writable := file == "comment"
+	forceTrunc := file != "comment"
+
 	var perm qp.FileMode
 	if writable {
 		perm = 0777
@@ -203,6 +208,10 @@ type IssueView struct {
 	project string
 	issueNo string
 
+	// issue, if set, is used instead of fetching the issue on every walk,
+	// such as when exporting.
+	issue *jira.Issue
+
 	issueLock sync.Mutex
 	newIssue  bool
 	values    map[string]string
@@ -211,7 +220,7 @@ type IssueView struct {
 func (iw *IssueView) normalFiles() (files, dirs []string) {
 	files = []string{"assignee", "creator", "ctl", "description", "type", "key", "reporter", "status",
 		"summary", "labels", "transition", "priority", "resolution", "raw", "progress", "links", "components",
-		"project"}
+		"project", "fixversions", "affectsversions", "deps", "deps.dot", "status.plan", "ctl.result"}
 	dirs = []string{"comments", "worklog"}
 	return
 }
@@ -322,9 +331,12 @@ func (iw *IssueView) normalWalk(jc *Client, file string) (trees.File, error) {
 		return nil, nil
 	}
 
-	issue, err := GetIssue(jc, iw.issueNo)
-	if err != nil {
-		return nil, err
+	issue := iw.issue
+	if issue == nil {
+		var err error
+		if issue, err = GetIssue(jc, iw.issueNo); err != nil {
+			return nil, err
+		}
 	}
 
 	forceTrunc := true
@@ -362,6 +374,11 @@ func (iw *IssueView) normalWalk(jc *Client, file string) (trees.File, error) {
 		if issue.Fields != nil && issue.Fields.Status != nil {
 			cnt = []byte(issue.Fields.Status.Name + "\n")
 		}
+	case "status.plan":
+		cnt = []byte(jc.results.Get(issue.Key + "/status.plan"))
+	case "ctl.result":
+		cnt = []byte(jc.results.Get(issue.Key + "/ctl.result"))
+		writable = false
 	case "priority":
 		if issue.Fields != nil && issue.Fields.Priority != nil {
 			cnt = []byte(issue.Fields.Priority.Name + "\n")
@@ -395,6 +412,23 @@ func (iw *IssueView) normalWalk(jc *Client, file string) (trees.File, error) {
 			cnt = []byte(s)
 		}
 		forceTrunc = false
+	case "fixversions", "affectsversions":
+		iv, err := GetVersionsForIssue(jc, issue.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		versions := iv.Fields.FixVersions
+		if file == "affectsversions" {
+			versions = iv.Fields.Versions
+		}
+
+		var s string
+		for _, v := range versions {
+			s += v.Name + "\n"
+		}
+		cnt = []byte(s)
+		forceTrunc = false
 	case "labels":
 		if issue.Fields != nil {
 			var s string
@@ -425,6 +459,18 @@ func (iw *IssueView) normalWalk(jc *Client, file string) (trees.File, error) {
 			cnt = []byte(s)
 		}
 		forceTrunc = false
+	case "deps", "deps.dot":
+		link, depth := jc.Deps()
+		dg, err := BuildDepGraph(jc, issue.Key, link, depth)
+		if err != nil {
+			return nil, err
+		}
+		if file == "deps" {
+			cnt = []byte(dg.Tree())
+		} else {
+			cnt = []byte(dg.Dot())
+		}
+		writable = false
 	case "comments":
 		return NewJiraDir(file,
 			0555|qp.DMDIR,
@@ -448,7 +494,107 @@ func (iw *IssueView) normalWalk(jc *Client, file string) (trees.File, error) {
 	case "ctl":
 		cmds := map[string]func([]string) error{
 			"delete": func(args []string) error {
-				return DeleteIssue(jc, issue.Key)
+				switch len(args) {
+				case 0:
+					window := jc.DeleteWindow()
+					token, err := jc.deletes.Arm(issue.Key, window)
+					if err != nil {
+						return err
+					}
+					jc.results.Set(issue.Key+"/ctl.result", fmt.Sprintf("write \"delete %s\" within %v to delete %s\n", token, window, issue.Key))
+					return nil
+				case 1:
+					if err := jc.deletes.Confirm(issue.Key, args[0]); err != nil {
+						return fmt.Errorf("could not confirm deletion: %v, write \"delete\" to arm it", err)
+					}
+					if err := SafeDeleteIssue(jc, issue.Key); err != nil {
+						return err
+					}
+					jc.results.Set(issue.Key+"/ctl.result", fmt.Sprintf("deleted, archived to %s\n", trashPath(jc, issue.Key)))
+					return nil
+				default:
+					return errors.New("invalid arguments")
+				}
+			},
+			"transition": func(args []string) error {
+				name, values, err := ParseTransitionInput(strings.Join(args, " "))
+				if err != nil {
+					return err
+				}
+				return TransitionIssueWithFields(jc, issue.Key, name, values)
+			},
+			"clone": func(args []string) error {
+				var project string
+				var subtasks bool
+				for _, arg := range args {
+					if arg == "--subtasks" {
+						subtasks = true
+					} else {
+						project = strings.ToUpper(arg)
+					}
+				}
+
+				newKey, err := CloneIssue(jc, issue.Key, project, "", subtasks)
+				if err != nil {
+					jc.results.Set(issue.Key+"/ctl.result", fmt.Sprintf("error: %v\n", err))
+					return err
+				}
+				jc.results.Set(issue.Key+"/ctl.result", newKey+"\n")
+				return nil
+			},
+			"move": func(args []string) error {
+				var force bool
+				var token string
+				var words []string
+				for i := 0; i < len(args); i++ {
+					switch args[i] {
+					case "--force":
+						force = true
+					case "--confirm":
+						if i+1 == len(args) {
+							return errors.New("token missing")
+						}
+						i++
+						token = args[i]
+					default:
+						words = append(words, args[i])
+					}
+				}
+				if len(words) < 1 {
+					return errors.New("project missing")
+				}
+				project, issueType := strings.ToUpper(words[0]), strings.Join(words[1:], " ")
+
+				// Moves to another project delete the original, so like
+				// deletions they must be confirmed. Type changes are made in
+				// place.
+				if issue.Fields == nil || !strings.EqualFold(issue.Fields.Project.Key, project) {
+					armKey := fmt.Sprintf("move:%s:%s:%s:%t", issue.Key, project, issueType, force)
+					if token == "" {
+						window := jc.DeleteWindow()
+						token, err := jc.deletes.Arm(armKey, window)
+						if err != nil {
+							return err
+						}
+						cmd := strings.TrimSpace(fmt.Sprintf("move %s --confirm %s", strings.Join(args, " "), token))
+						jc.results.Set(issue.Key+"/ctl.result", fmt.Sprintf("write \"%s\" within %v to move %s to %s\n", cmd, window, issue.Key, project))
+						return nil
+					}
+					if err := jc.deletes.Confirm(armKey, token); err != nil {
+						return fmt.Errorf("could not confirm move: %v, write the command without --confirm to arm it", err)
+					}
+				}
+
+				newKey, notes, err := MoveIssue(jc, issue.Key, project, issueType, force)
+				reportMove(jc, issue.Key, newKey, notes, err)
+				return err
+			},
+			"goto": func(args []string) error {
+				target, via, avoid, err := ParseStatusTarget(args)
+				if err != nil {
+					return err
+				}
+				return ChangeStatus(jc, issue.Key, target, via, avoid)
 			},
 		}
 		return NewCommandFile("ctl", 0777, "jira", "jira", cmds), nil
@@ -521,9 +667,12 @@ func (iw *IssueView) normalWalk(jc *Client, file string) (trees.File, error) {
 			sf.RLock()
 			str := string(sf.Content)
 			sf.RUnlock()
-			str = strings.Replace(str, "\n", "", -1)
 
-			return TransitionIssue(jc, issue.Key, str)
+			name, values, err := ParseTransitionInput(str)
+			if err != nil {
+				return err
+			}
+			return TransitionIssueWithFields(jc, issue.Key, name, values)
 
 		case "status":
 			sf.RLock()
@@ -531,55 +680,32 @@ func (iw *IssueView) normalWalk(jc *Client, file string) (trees.File, error) {
 			sf.RUnlock()
 			str = strings.Replace(str, "\n", "", -1)
 
-			issue, err := GetIssue(jc, iw.issueNo)
-			if err != nil {
-				log.Printf("Could not fetch issue: %v", err)
-				return err
-			}
-			if issue.Fields == nil {
-				log.Printf("Issue missing fields")
-				return errors.New("oops")
-			}
-			if issue.Fields.Status == nil {
-				log.Printf("Issue missing status")
-				return errors.New("oops2")
-			}
+			return ChangeStatus(jc, issue.Key, str, nil, nil)
+
+		case "status.plan":
+			sf.RLock()
+			str := string(sf.Content)
+			sf.RUnlock()
 
-			wg, err := BuildWorkflow2(jc, iw.project, issue.Fields.Type.ID)
+			target, via, avoid, err := ParseStatusTarget(strings.Fields(str))
 			if err != nil {
-				log.Printf("Could not build workflow: %v", err)
 				return err
 			}
 
-			p, err := wg.Path(issue.Fields.Status.Name, str, 500)
+			sp, err := PlanStatusChange(jc, issue.Key, target, via, avoid)
 			if err != nil {
-				log.Printf("Could not find path: %v", err)
-				log.Printf("Workflow: \n%s\n", wg.Dump())
+				jc.results.Set(issue.Key+"/status.plan", fmt.Sprintf("error: %v\n", err))
 				return err
 			}
 
-			log.Printf("Workflow path: %s", strings.Join(p, ", "))
-
-			for _, s := range p {
-				err = TransitionIssue(jc, issue.Key, s)
-				if err != nil {
-					log.Printf("Could not transition issue: %v", err)
-					return err
-				}
-			}
-
+			jc.results.Set(issue.Key+"/status.plan", sp.String())
 			return nil
 
 		default:
 			sf.RLock()
 			str := string(sf.Content)
 			sf.RUnlock()
-			switch file {
-			case "description", "labels", "components":
-			default:
-				str = strings.Replace(str, "\n", "", -1)
-			}
-			return SetFieldInIssue(jc, issue.Key, file, str)
+			return SetFieldInIssue(jc, issue.Key, file, normalizeFieldValue(file, str))
 		}
 	}
 
@@ -592,6 +718,17 @@ func (iw *IssueView) normalWalk(jc *Client, file string) (trees.File, error) {
 	return sf, nil
 }
 
+// normalizeFieldValue strips newlines from the value written to a field
+// file, unless the field is multi-line.
+func normalizeFieldValue(field, str string) string {
+	switch field {
+	case "description", "labels", "components", "fixversions", "affectsversions":
+		return str
+	default:
+		return strings.Replace(str, "\n", "", -1)
+	}
+}
+
 func (iw *IssueView) Walk(jc *Client, file string) (trees.File, error) {
 	iw.issueLock.Lock()
 	isNew := iw.newIssue
@@ -623,25 +760,271 @@ func (iw *IssueView) List(jc *Client) ([]qp.Stat, error) {
 	return stats, nil
 }
 
+// listingColumns returns the columns configured for the issue listing
+// identified by id.
+func listingColumns(jc *Client, id string) []string {
+	if s := jc.results.Get(id + "/columns"); s != "" {
+		return strings.Fields(s)
+	}
+	return DefaultIndexColumns
+}
+
+// indexFile returns the index or columns file of an issue listing, where id
+// identifies the listing for the column configuration.
+func indexFile(jc *Client, file, id, query string, max int) (trees.File, error) {
+	columns := listingColumns(jc, id)
+
+	switch file {
+	case "index":
+		idx, err := GetIndexForSearch(jc, query, columns, max)
+		if err != nil {
+			return nil, err
+		}
+		sf := trees.NewSyntheticFile(file, 0555, "jira", "jira")
+		sf.SetContent([]byte(idx))
+		return sf, nil
+	case "columns":
+		sf := trees.NewSyntheticFile(file, 0777, "jira", "jira")
+		sf.SetContent([]byte(strings.Join(columns, " ") + "\n"))
+
+		onClose := func() error {
+			sf.RLock()
+			str := string(sf.Content)
+			sf.RUnlock()
+
+			jc.results.Set(id+"/columns", strings.Join(strings.Fields(str), " "))
+			return nil
+		}
+
+		cs := NewCloseSaver(sf, onClose)
+		cs.forceTrunc = true
+		return cs, nil
+	default:
+		return nil, nil
+	}
+}
+
+// SearchView is a folder of the issues matching a query. id identifies the
+// search for its report and column configuration, which must not change when
+// the query is edited.
 type SearchView struct {
-	query      string
 	resultLock sync.Mutex
+	id         string
+	query      string
+	order      string
+	limit      int
+	searched   bool
 	results    []string
+	count      int
+	lastrun    time.Time
+}
+
+func (sw *SearchView) setQuery(query string) {
+	sw.resultLock.Lock()
+	defer sw.resultLock.Unlock()
+	sw.query = query
+}
+
+// jql returns the query of the search, including the ordering. The order
+// setting replaces any ORDER BY clause of the query.
+func (sw *SearchView) jql() string {
+	sw.resultLock.Lock()
+	defer sw.resultLock.Unlock()
+	query, order := SplitOrderBy(sw.query)
+	if sw.order != "" {
+		order = sw.order
+	}
+	if order == "" {
+		return query
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s ORDER BY %s", query, order))
 }
 
 func (sw *SearchView) search(jc *Client) error {
-	keys, err := GetKeysForSearch(jc, sw.query, jc.maxlisting)
+	sw.resultLock.Lock()
+	limit := sw.limit
+	sw.resultLock.Unlock()
+	if limit <= 0 {
+		limit = jc.maxlisting
+	}
+
+	keys, count, err := GetKeysAndTotalForSearch(jc, sw.jql(), limit)
 	if err != nil {
 		return err
 	}
 
 	sw.resultLock.Lock()
 	sw.results = keys
+	sw.count = count
+	sw.lastrun = time.Now()
+	sw.searched = true
 	sw.resultLock.Unlock()
 	return nil
 }
 
+// settingFile returns a file for one of the query, order and limit settings of
+// the search.
+func (sw *SearchView) settingFile(file string) trees.File {
+	sw.resultLock.Lock()
+	var cnt string
+	switch file {
+	case "query":
+		cnt = sw.query + "\n"
+	case "order":
+		if sw.order != "" {
+			cnt = sw.order + "\n"
+		}
+	case "limit":
+		if sw.limit > 0 {
+			cnt = strconv.Itoa(sw.limit) + "\n"
+		}
+	}
+	sw.resultLock.Unlock()
+
+	sf := trees.NewSyntheticFile(file, 0777, "jira", "jira")
+	sf.SetContent([]byte(cnt))
+
+	onClose := func() error {
+		sf.RLock()
+		str := strings.TrimSpace(strings.Replace(string(sf.Content), "\n", " ", -1))
+		sf.RUnlock()
+
+		sw.resultLock.Lock()
+		defer sw.resultLock.Unlock()
+		switch file {
+		case "query":
+			if str == "" {
+				return errors.New("query missing")
+			}
+			sw.query = str
+		case "order":
+			sw.order = str
+		case "limit":
+			if str == "" {
+				sw.limit = 0
+				return nil
+			}
+			limit, err := strconv.Atoi(str)
+			if err != nil {
+				return err
+			}
+			sw.limit = limit
+		}
+		return nil
+	}
+
+	cs := NewCloseSaver(sf, onClose)
+	cs.forceTrunc = true
+	return cs
+}
+
+func (sw *SearchView) bulkCtl(jc *Client) *CommandFile {
+	bulk := func(cmd string) func([]string) error {
+		return func(args []string) error {
+			op, err := BulkOp(jc, cmd, args)
+			if err != nil {
+				return err
+			}
+
+			query := sw.jql()
+			keys, err := GetAllKeysForSearch(jc, query)
+			if err != nil {
+				return err
+			}
+
+			results := RunBulk(jc, keys, op)
+			line := strings.TrimSpace(cmd + " " + strings.Join(args, " "))
+			jc.results.Set(sw.id+"/report", RenderBulkReport(line, results))
+
+			var failed int
+			for _, r := range results {
+				if r.Err != nil && !IsQueued(r.Err) {
+					failed++
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d issues failed", failed, len(results))
+			}
+			return nil
+		}
+	}
+
+	cmds := make(map[string]func([]string) error)
+	for _, cmd := range []string{"set", "assign", "label", "transition", "comment"} {
+		cmds[cmd] = bulk(cmd)
+	}
+	cmds["git-export"] = func(args []string) error {
+		query := sw.jql()
+		keys, err := GetAllKeysForSearch(jc, query)
+		if err != nil {
+			return err
+		}
+
+		res, err := GitExport(jc, keys)
+		if err != nil {
+			res += fmt.Sprintf("error: %v\n", err)
+		}
+		jc.results.Set(sw.id+"/report", "git-export\n"+res)
+		return err
+	}
+	return NewCommandFile("ctl", 0777, "jira", "jira", cmds)
+}
+
 func (sw *SearchView) Walk(jc *Client, file string) (trees.File, error) {
+	switch file {
+	case "ctl":
+		return sw.bulkCtl(jc), nil
+	case "report":
+		sf := trees.NewSyntheticFile(file, 0555, "jira", "jira")
+		sf.SetContent([]byte(jc.results.Get(sw.id + "/report")))
+		return sf, nil
+	case "query", "order", "limit":
+		return sw.settingFile(file), nil
+	case "export.csv", "export.json", "export.jsonl":
+		return exportFile(jc, file, sw.id, sw.jql()), nil
+	case "index", "columns":
+		sw.resultLock.Lock()
+		limit := sw.limit
+		sw.resultLock.Unlock()
+		if limit <= 0 {
+			limit = jc.maxlisting
+		}
+		return indexFile(jc, file, sw.id, sw.jql(), limit)
+	case "events":
+		sw.resultLock.Lock()
+		query, _ := SplitOrderBy(sw.query)
+		sw.resultLock.Unlock()
+		return NewEventFile(file, jc, query), nil
+	case "count", "lastrun":
+		sw.resultLock.Lock()
+		var cnt string
+		switch {
+		case !sw.searched:
+		case file == "count":
+			cnt = strconv.Itoa(sw.count) + "\n"
+		default:
+			cnt = sw.lastrun.Format(time.RFC3339) + "\n"
+		}
+		sw.resultLock.Unlock()
+
+		sf := trees.NewSyntheticFile(file, 0555, "jira", "jira")
+		sf.SetContent([]byte(cnt))
+		return sf, nil
+	}
+
+	sw.resultLock.Lock()
+	searched := sw.searched
+	sw.resultLock.Unlock()
+
+	// Searches that have never been listed are run once, but the search
+	// does not update on every access to avoid significant performance
+	// issues.
+	if !searched {
+		if err := sw.search(jc); err != nil {
+			return nil, err
+		}
+	}
+
 	sw.resultLock.Lock()
 	keys := sw.results
 	sw.resultLock.Unlock()
@@ -676,7 +1059,10 @@ func (sw *SearchView) List(jc *Client) ([]qp.Stat, error) {
 	keys := sw.results
 	sw.resultLock.Unlock()
 
-	return StringsToStats(keys, 0555|qp.DMDIR, "jira", "jira"), nil
+	a := StringsToStats(keys, 0555|qp.DMDIR, "jira", "jira")
+	b := StringsToStats([]string{"ctl", "query", "order", "limit", "columns"}, 0777, "jira", "jira")
+	c := StringsToStats(append([]string{"report", "count", "lastrun", "index", "events"}, ExportFormats...), 0555, "jira", "jira")
+	return append(append(a, b...), c...), nil
 }
 
 type ProjectIssuesView struct {
@@ -684,6 +1070,12 @@ type ProjectIssuesView struct {
 }
 
 func (piw *ProjectIssuesView) Walk(jc *Client, issueNo string) (trees.File, error) {
+	switch issueNo {
+	case "index", "columns":
+		query := fmt.Sprintf("project = %s", piw.project)
+		return indexFile(jc, issueNo, "project:"+piw.project, query, jc.maxlisting)
+	}
+
 	iw := &IssueView{
 		project: piw.project,
 	}
@@ -708,6 +1100,14 @@ func (piw *ProjectIssuesView) Walk(jc *Client, issueNo string) (trees.File, erro
 	return NewJiraDir(issueNo, 0555|qp.DMDIR, "jira", "jira", jc, iw)
 }
 
+func (piw *ProjectIssuesView) Rename(jc *Client, oldname, newname string) error {
+	if _, err := strconv.ParseUint(oldname, 10, 64); err != nil {
+		return trees.ErrPermissionDenied
+	}
+
+	return RenameIssue(jc, fmt.Sprintf("%s-%s", piw.project, oldname), newname)
+}
+
 func (piw *ProjectIssuesView) List(jc *Client) ([]qp.Stat, error) {
 	keys, err := GetKeysForNIssuesInProject(jc, piw.project, jc.maxlisting)
 	if err != nil {
@@ -716,47 +1116,576 @@ func (piw *ProjectIssuesView) List(jc *Client) ([]qp.Stat, error) {
 	}
 
 	keys = append(keys, "new")
-	return StringsToStats(keys, 0555|qp.DMDIR, "jira", "jira"), nil
+	a := StringsToStats(keys, 0555|qp.DMDIR, "jira", "jira")
+	b := StringsToStats([]string{"columns"}, 0777, "jira", "jira")
+	c := StringsToStats([]string{"index"}, 0555, "jira", "jira")
+	return append(append(a, b...), c...), nil
 }
 
-type ProjectView struct {
+type VersionView struct {
 	project string
+	version string
 }
 
-func (pw *ProjectView) Walk(jc *Client, file string) (trees.File, error) {
+func (vv *VersionView) Walk(jc *Client, file string) (trees.File, error) {
 	switch file {
 	case "issues":
-		piw := &ProjectIssuesView{project: pw.project}
-		return NewJiraDir(file, 0555|qp.DMDIR, "jira", "jira", jc, piw)
-	case "components":
-		project, err := GetProject(jc, pw.project)
-		if err != nil {
-			return nil, err
-		}
+		query := fmt.Sprintf("project = %s AND fixVersion = %s", vv.project, JQLQuote(vv.version))
+		sw := &SearchView{id: "version:" + vv.project + "/" + vv.version, query: query}
+		return NewJiraDir(file, 0555|qp.DMDIR, "jira", "jira", jc, sw)
+	case "description", "releasedate", "released":
+	default:
+		return nil, nil
+	}
 
-		var components string
-		for _, c := range project.Components {
-			components += c.Name + "\n"
-		}
+	version, err := GetVersionByName(jc, vv.project, vv.version)
+	if err != nil {
+		return nil, err
+	}
 
-		sf := trees.NewSyntheticFile(file, 0555, "jira", "jira")
-		sf.SetContent([]byte(components))
-		return sf, nil
-	case "issuetypes":
-		project, err := GetProject(jc, pw.project)
-		if err != nil {
-			return nil, err
+	var cnt []byte
+	switch file {
+	case "description":
+		cnt = []byte(version.Description + "\n")
+	case "releasedate":
+		if version.ReleaseDate != "" {
+			cnt = []byte(version.ReleaseDate + "\n")
 		}
+	case "released":
+		cnt = []byte(strconv.FormatBool(version.Released) + "\n")
+	}
 
-		var issuetypes string
-		for _, tp := range project.IssueTypes {
-			issuetypes += tp.Name + "\n"
-		}
-		sf := trees.NewSyntheticFile(file, 0555, "jira", "jira")
-		sf.SetContent([]byte(issuetypes))
-		return sf, nil
-	case "raw":
-		project, err := GetProject(jc, pw.project)
+	sf := trees.NewSyntheticFile(file, 0777, "jira", "jira")
+	sf.SetContent(cnt)
+
+	onClose := func() error {
+		sf.RLock()
+		str := string(sf.Content)
+		sf.RUnlock()
+
+		fields := make(map[string]interface{})
+		switch file {
+		case "description":
+			fields["description"] = strings.TrimRight(str, "\n")
+		case "releasedate":
+			fields["releaseDate"] = strings.Replace(str, "\n", "", -1)
+		case "released":
+			released, err := strconv.ParseBool(strings.Replace(str, "\n", "", -1))
+			if err != nil {
+				return err
+			}
+			fields["released"] = released
+		}
+
+		return UpdateVersion(jc, version.ID, fields)
+	}
+
+	cs := NewCloseSaver(sf, onClose)
+	cs.forceTrunc = true
+	return cs, nil
+}
+
+func (vv *VersionView) List(jc *Client) ([]qp.Stat, error) {
+	a := StringsToStats([]string{"description", "releasedate", "released"}, 0777, "jira", "jira")
+	b := StringsToStats([]string{"issues"}, 0555|qp.DMDIR, "jira", "jira")
+	return append(a, b...), nil
+}
+
+type ProjectVersionsView struct {
+	project string
+}
+
+func (pvw *ProjectVersionsView) Walk(jc *Client, file string) (trees.File, error) {
+	switch file {
+	case "ctl":
+		cmds := map[string]func([]string) error{
+			"create": func(args []string) error {
+				if len(args) < 1 {
+					return errors.New("version name missing")
+				}
+				return CreateVersion(jc, pvw.project, strings.Join(args, " "))
+			},
+			"release": func(args []string) error {
+				if len(args) < 1 {
+					return errors.New("version name missing")
+				}
+				version, err := GetVersionByName(jc, pvw.project, strings.Join(args, " "))
+				if err != nil {
+					return err
+				}
+				fields := map[string]interface{}{
+					"released": true,
+				}
+				if version.ReleaseDate == "" {
+					fields["releaseDate"] = time.Now().Format("2006-01-02")
+				}
+				return UpdateVersion(jc, version.ID, fields)
+			},
+			"archive": func(args []string) error {
+				if len(args) < 1 {
+					return errors.New("version name missing")
+				}
+				version, err := GetVersionByName(jc, pvw.project, strings.Join(args, " "))
+				if err != nil {
+					return err
+				}
+				return UpdateVersion(jc, version.ID, map[string]interface{}{"archived": true})
+			},
+			"merge": func(args []string) error {
+				// Version names containing spaces must be quoted.
+				args, err := SplitQuoted(strings.Join(args, " "))
+				if err != nil {
+					return err
+				}
+				if len(args) != 2 {
+					return errors.New("invalid arguments")
+				}
+				from, err := GetVersionByName(jc, pvw.project, args[0])
+				if err != nil {
+					return err
+				}
+				to, err := GetVersionByName(jc, pvw.project, args[1])
+				if err != nil {
+					return err
+				}
+				return MergeVersion(jc, from.ID, to.ID)
+			},
+		}
+		return NewCommandFile("ctl", 0777, "jira", "jira", cmds), nil
+	default:
+		if _, err := GetVersionByName(jc, pvw.project, file); err != nil {
+			return nil, nil
+		}
+		vv := &VersionView{project: pvw.project, version: file}
+		return NewJiraDir(file, 0555|qp.DMDIR, "jira", "jira", jc, vv)
+	}
+}
+
+func (pvw *ProjectVersionsView) List(jc *Client) ([]qp.Stat, error) {
+	versions, err := GetVersionsForProject(jc, pvw.project)
+	if err != nil {
+		log.Printf("Could not generate version list: %v", err)
+		return nil, err
+	}
+
+	var strs []string
+	for _, v := range versions {
+		strs = append(strs, v.Name)
+	}
+
+	a := StringsToStats(strs, 0555|qp.DMDIR, "jira", "jira")
+	b := StringsToStats([]string{"ctl"}, 0777, "jira", "jira")
+	return append(a, b...), nil
+}
+
+type ComponentView struct {
+	project   string
+	component string
+}
+
+func (cv *ComponentView) Walk(jc *Client, file string) (trees.File, error) {
+	switch file {
+	case "issues":
+		query := fmt.Sprintf("project = %s AND component = %s", cv.project, JQLQuote(cv.component))
+		sw := &SearchView{id: "component:" + cv.project + "/" + cv.component, query: query}
+		return NewJiraDir(file, 0555|qp.DMDIR, "jira", "jira", jc, sw)
+	case "lead", "description", "assignee", "assigneetype":
+	default:
+		return nil, nil
+	}
+
+	component, err := GetComponentByName(jc, cv.project, cv.component)
+	if err != nil {
+		return nil, err
+	}
+
+	writable := true
+	var cnt []byte
+	switch file {
+	case "lead":
+		if component.Lead != nil {
+			cnt = []byte(component.Lead.Name + "\n")
+		}
+	case "description":
+		cnt = []byte(component.Description + "\n")
+	case "assignee":
+		if component.RealAssignee != nil {
+			cnt = []byte(component.RealAssignee.Name + "\n")
+		}
+		writable = false
+	case "assigneetype":
+		cnt = []byte(component.AssigneeType + "\n")
+	}
+
+	if !writable {
+		sf := trees.NewSyntheticFile(file, 0555, "jira", "jira")
+		sf.SetContent(cnt)
+		return sf, nil
+	}
+
+	sf := trees.NewSyntheticFile(file, 0777, "jira", "jira")
+	sf.SetContent(cnt)
+
+	onClose := func() error {
+		sf.RLock()
+		str := string(sf.Content)
+		sf.RUnlock()
+
+		fields := make(map[string]interface{})
+		switch file {
+		case "lead":
+			fields["leadUserName"] = strings.Replace(str, "\n", "", -1)
+		case "description":
+			fields["description"] = strings.TrimRight(str, "\n")
+		case "assigneetype":
+			fields["assigneeType"] = strings.Replace(str, "\n", "", -1)
+		}
+
+		return UpdateComponent(jc, component.ID, fields)
+	}
+
+	cs := NewCloseSaver(sf, onClose)
+	cs.forceTrunc = true
+	return cs, nil
+}
+
+func (cv *ComponentView) List(jc *Client) ([]qp.Stat, error) {
+	a := StringsToStats([]string{"lead", "description", "assigneetype"}, 0777, "jira", "jira")
+	b := StringsToStats([]string{"assignee"}, 0555, "jira", "jira")
+	c := StringsToStats([]string{"issues"}, 0555|qp.DMDIR, "jira", "jira")
+	return append(append(a, b...), c...), nil
+}
+
+type ProjectComponentsView struct {
+	project string
+}
+
+func (pcw *ProjectComponentsView) Walk(jc *Client, file string) (trees.File, error) {
+	if _, err := GetComponentByName(jc, pcw.project, file); err != nil {
+		return nil, nil
+	}
+
+	cv := &ComponentView{project: pcw.project, component: file}
+	return NewJiraDir(file, 0777|qp.DMDIR, "jira", "jira", jc, cv)
+}
+
+func (pcw *ProjectComponentsView) List(jc *Client) ([]qp.Stat, error) {
+	components, err := GetComponentsForProject(jc, pcw.project)
+	if err != nil {
+		log.Printf("Could not generate component list: %v", err)
+		return nil, err
+	}
+
+	var strs []string
+	for _, c := range components {
+		strs = append(strs, c.Name)
+	}
+
+	return StringsToStats(strs, 0777|qp.DMDIR, "jira", "jira"), nil
+}
+
+func (pcw *ProjectComponentsView) Create(jc *Client, name string, perms qp.FileMode) (trees.File, error) {
+	if perms&qp.DMDIR == 0 {
+		return nil, trees.ErrPermissionDenied
+	}
+
+	if err := CreateComponent(jc, pcw.project, name); err != nil {
+		return nil, err
+	}
+
+	cv := &ComponentView{project: pcw.project, component: name}
+	return NewJiraDir(name, 0777|qp.DMDIR, "jira", "jira", jc, cv)
+}
+
+func (pcw *ProjectComponentsView) Remove(jc *Client, name string) error {
+	component, err := GetComponentByName(jc, pcw.project, name)
+	if err != nil {
+		return trees.ErrNoSuchFile
+	}
+
+	return DeleteComponent(jc, component.ID)
+}
+
+type EpicView struct {
+	issueNo string
+	iw      *IssueView
+}
+
+func (ev *EpicView) Walk(jc *Client, file string) (trees.File, error) {
+	switch file {
+	case "children":
+		query, err := EpicChildrenQuery(jc, ev.issueNo)
+		if err != nil {
+			return nil, err
+		}
+		sw := &SearchView{id: "epic:" + ev.issueNo, query: query}
+		return NewJiraDir(file, 0555|qp.DMDIR, "jira", "jira", jc, sw)
+	case "childkeys":
+		field, err := GetEpicLinkField(jc)
+		if err != nil {
+			return nil, err
+		}
+		query, err := EpicChildrenQuery(jc, ev.issueNo)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := GetAllKeysForSearch(jc, query)
+		if err != nil {
+			return nil, err
+		}
+
+		var s string
+		for _, k := range keys {
+			s += k + "\n"
+		}
+
+		sf := trees.NewSyntheticFile(file, 0777, "jira", "jira")
+		sf.SetContent([]byte(s))
+
+		onClose := func() error {
+			sf.RLock()
+			str := string(sf.Content)
+			sf.RUnlock()
+
+			cur := make(map[string]bool)
+			for _, k := range keys {
+				cur[k] = true
+			}
+
+			// Figure out which children are new, and which are old.
+			var new []string
+			for _, k := range strings.Split(str, "\n") {
+				k = strings.ToUpper(strings.TrimSpace(k))
+				if k == "" {
+					continue
+				}
+				if cur[k] {
+					delete(cur, k)
+				} else {
+					new = append(new, k)
+				}
+			}
+
+			var errs []string
+			for k := range cur {
+				if err := SetFieldInIssue(jc, k, field, ""); err != nil && !IsQueued(err) {
+					errs = append(errs, fmt.Sprintf("could not remove %s: %v", k, err))
+				}
+			}
+
+			for _, k := range new {
+				if err := SetFieldInIssue(jc, k, field, ev.issueNo); err != nil && !IsQueued(err) {
+					errs = append(errs, fmt.Sprintf("could not add %s: %v", k, err))
+				}
+			}
+
+			if len(errs) > 0 {
+				return fmt.Errorf("could not update children of epic %s: %s", ev.issueNo, strings.Join(errs, "; "))
+			}
+			return nil
+		}
+
+		return NewCloseSaver(sf, onClose), nil
+	default:
+		return ev.iw.Walk(jc, file)
+	}
+}
+
+func (ev *EpicView) List(jc *Client) ([]qp.Stat, error) {
+	stats, err := ev.iw.List(jc)
+	if err != nil {
+		return nil, err
+	}
+
+	a := StringsToStats([]string{"childkeys"}, 0777, "jira", "jira")
+	b := StringsToStats([]string{"children"}, 0555|qp.DMDIR, "jira", "jira")
+	return append(append(stats, a...), b...), nil
+}
+
+type ProjectEpicsView struct {
+	project string
+}
+
+func (pew *ProjectEpicsView) Walk(jc *Client, file string) (trees.File, error) {
+	issue, err := GetIssue(jc, file)
+	if err != nil {
+		return nil, nil
+	}
+
+	if issue.Fields == nil || issue.Fields.Type.Name != "Epic" || issue.Fields.Project.Key != pew.project {
+		return nil, nil
+	}
+
+	ev := &EpicView{
+		issueNo: issue.Key,
+		iw: &IssueView{
+			project: pew.project,
+			issueNo: issue.Key,
+		},
+	}
+	return NewJiraDir(file, 0555|qp.DMDIR, "jira", "jira", jc, ev)
+}
+
+func (pew *ProjectEpicsView) List(jc *Client) ([]qp.Stat, error) {
+	query := fmt.Sprintf("project = %s AND issuetype = Epic", pew.project)
+	keys, err := GetKeysForSearch(jc, query, jc.maxlisting)
+	if err != nil {
+		log.Printf("Could not generate epic list: %v", err)
+		return nil, err
+	}
+
+	return StringsToStats(keys, 0555|qp.DMDIR, "jira", "jira"), nil
+}
+
+type WorkflowView struct {
+	project     string
+	issueTypeNo string
+}
+
+func (wv *WorkflowView) Walk(jc *Client, file string) (trees.File, error) {
+	if !StringExistsInSets(file, []string{"statuses", "transitions", "graph.dot"}) {
+		return nil, nil
+	}
+
+	wg, err := GetWorkflow(jc, wv.project, wv.issueTypeNo, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var cnt string
+	switch file {
+	case "statuses":
+		for _, s := range wg.Statuses() {
+			cnt += s + "\n"
+		}
+	case "transitions":
+		cnt = wg.Transitions()
+	case "graph.dot":
+		cnt = wg.Dot()
+	}
+
+	sf := trees.NewSyntheticFile(file, 0555, "jira", "jira")
+	sf.SetContent([]byte(cnt))
+	return sf, nil
+}
+
+func (wv *WorkflowView) List(jc *Client) ([]qp.Stat, error) {
+	return StringsToStats([]string{"statuses", "transitions", "graph.dot"}, 0555, "jira", "jira"), nil
+}
+
+type ProjectWorkflowsView struct {
+	project string
+}
+
+func (pwv *ProjectWorkflowsView) Walk(jc *Client, file string) (trees.File, error) {
+	id, err := GetTypeIDForProject(jc, pwv.project, file)
+	if err != nil {
+		return nil, nil
+	}
+
+	wv := &WorkflowView{project: pwv.project, issueTypeNo: id}
+	return NewJiraDir(file, 0555|qp.DMDIR, "jira", "jira", jc, wv)
+}
+
+func (pwv *ProjectWorkflowsView) List(jc *Client) ([]qp.Stat, error) {
+	types, err := GetTypesForProject(jc, pwv.project)
+	if err != nil {
+		log.Printf("Could not generate workflow list: %v", err)
+		return nil, err
+	}
+
+	return StringsToStats(types, 0555|qp.DMDIR, "jira", "jira"), nil
+}
+
+type ProjectView struct {
+	project string
+}
+
+func (pw *ProjectView) Walk(jc *Client, file string) (trees.File, error) {
+	switch file {
+	case "issues":
+		piw := &ProjectIssuesView{project: pw.project}
+		return NewJiraDir(file, 0555|qp.DMDIR, "jira", "jira", jc, piw)
+	case "epics":
+		pew := &ProjectEpicsView{project: pw.project}
+		return NewJiraDir(file, 0555|qp.DMDIR, "jira", "jira", jc, pew)
+	case "versions":
+		pvw := &ProjectVersionsView{project: pw.project}
+		return NewJiraDir(file, 0555|qp.DMDIR, "jira", "jira", jc, pvw)
+	case "workflows":
+		pwv := &ProjectWorkflowsView{project: pw.project}
+		return NewJiraDir(file, 0555|qp.DMDIR, "jira", "jira", jc, pwv)
+	case "components":
+		pcw := &ProjectComponentsView{project: pw.project}
+		return NewJiraDir(file, 0777|qp.DMDIR, "jira", "jira", jc, pcw)
+	case "issuetypes":
+		project, err := GetProject(jc, pw.project)
+		if err != nil {
+			return nil, err
+		}
+
+		var issuetypes string
+		for _, tp := range project.IssueTypes {
+			issuetypes += tp.Name + "\n"
+		}
+		sf := trees.NewSyntheticFile(file, 0555, "jira", "jira")
+		sf.SetContent([]byte(issuetypes))
+		return sf, nil
+	case "export.csv", "export.json", "export.jsonl":
+		query := fmt.Sprintf("project = %s", pw.project)
+		return exportFile(jc, file, "project:"+pw.project, query), nil
+	case "import":
+		sf := trees.NewSyntheticFile(file, 0777, "jira", "jira")
+		onClose := func() error {
+			sf.RLock()
+			b := append([]byte(nil), sf.Content...)
+			sf.RUnlock()
+
+			rows, err := ParseImport(b)
+			if err != nil {
+				jc.results.Set("project:"+pw.project+"/import.result", fmt.Sprintf("error: %v\n", err))
+				return err
+			}
+
+			res, err := ImportIssues(jc, pw.project, rows)
+			if err != nil {
+				jc.results.Set("project:"+pw.project+"/import.result", fmt.Sprintf("error: %v\n", err))
+				return err
+			}
+
+			jc.results.Set("project:"+pw.project+"/import.result", res)
+			return nil
+		}
+		cs := NewCloseSaver(sf, onClose)
+		cs.forceTrunc = true
+		return cs, nil
+	case "import.result":
+		sf := trees.NewSyntheticFile(file, 0555, "jira", "jira")
+		sf.SetContent([]byte(jc.results.Get("project:" + pw.project + "/import.result")))
+		return sf, nil
+	case "ctl":
+		cmds := map[string]func([]string) error{
+			"git-export": func(args []string) error {
+				keys, err := GetAllKeysForSearch(jc, fmt.Sprintf("project = %s", pw.project))
+				if err != nil {
+					return err
+				}
+
+				res, err := GitExport(jc, keys)
+				if err != nil {
+					res += fmt.Sprintf("error: %v\n", err)
+				}
+				jc.results.Set("project:"+pw.project+"/ctl.result", res)
+				return err
+			},
+		}
+		return NewCommandFile("ctl", 0777, "jira", "jira", cmds), nil
+	case "ctl.result":
+		sf := trees.NewSyntheticFile(file, 0555, "jira", "jira")
+		sf.SetContent([]byte(jc.results.Get("project:" + pw.project + "/ctl.result")))
+		return sf, nil
+	case "raw":
+		project, err := GetProject(jc, pw.project)
 		if err != nil {
 			return nil, err
 		}
@@ -773,7 +1702,10 @@ func (pw *ProjectView) Walk(jc *Client, file string) (trees.File, error) {
 }
 
 func (pw *ProjectView) List(jc *Client) ([]qp.Stat, error) {
-	return StringsToStats([]string{"issues", "issuetypes", "components", "raw"}, 0555|qp.DMDIR, "jira", "jira"), nil
+	a := StringsToStats([]string{"issues", "issuetypes", "components", "epics", "versions", "workflows", "raw"}, 0555|qp.DMDIR, "jira", "jira")
+	b := StringsToStats(append([]string{"import.result", "ctl.result"}, ExportFormats...), 0555, "jira", "jira")
+	c := StringsToStats([]string{"import", "ctl"}, 0777, "jira", "jira")
+	return append(append(a, b...), c...), nil
 }
 
 type AllProjectsView struct{}
@@ -819,16 +1751,30 @@ func (aiv *AllIssuesView) Walk(jc *Client, issueKey string) (trees.File, error)
 
 	if issueKey == "new" {
 		iw.newIssue = true
+	} else if issueKey == "index" || issueKey == "columns" {
+		return indexFile(jc, issueKey, "issues", "", jc.maxlisting)
+	} else if issueKey == "moved" {
+		sf := trees.NewSyntheticFile(issueKey, 0555, "jira", "jira")
+		sf.SetContent([]byte(jc.results.Get("moved")))
+		return sf, nil
 	} else if issueKey == "help" {
 		message := `new/: New is a folder that creates a new skeleton issue when entered. It only contains a minimal set of files necessary to create the issue. Once all fields have been filled out, writing "commit" to the ctl file will cause the issue to be created. The issue folder will change to be that of a created issue, with all files available. Read the "key" file to figure out what issue key your issue received.
+index: A tab-separated table of the listed issues, with the columns listed in the writable columns file. The same files exist in project issue folders and search folders.
+Search folders and project folders also contain export.csv, export.json and export.jsonl files, exporting all matching issues with the configured columns.
+Writing "git-export" to the ctl file of a search folder or project folder writes the matching issues, in the layout of their issue folders, into the git repository given by the -git flag, committing each changed issue with the author and time of its latest change.
 ABC-1/: A folder containing information for ticket '1' in project 'ABC'.
+Renaming ABC-1/ to DEF moves the issue to project DEF, like the move command, but without confirmation. The new key is listed in moved, and the outcome is in the ctl.result file of the new issue. Renames only work within a directory.
+moved: The issues moved by jirafs, one "OLD-KEY NEW-KEY" pair per line.
 ABC-1/comments/: A folder containing comments for the issue. Writing to the comment file creates a new comment. Writing to an existing comment changes it. This structure may change in the future.
 ABC-1/components: A list of components this issue applies to. Writable. Note that the component names are case sensitive, and must be match an existing component for the project.
-ABC-1/ctl: A command file. On a new issue, the only accepted command is "commit", which creates the issue with the provided parameters. For existing issues, the only accepted command is "delete". In the future, more commands may be made available for things that map poorly to files.
+ABC-1/ctl: A command file. On a new issue, the only accepted command is "commit", which creates the issue with the provided parameters. For existing issues, the accepted commands are "delete" (which must be confirmed with "delete token", see ctl.result), "clone [PROJECT] [--subtasks]", "move PROJECT [TYPE] [--force]" (which must be confirmed with "--confirm token" when moving to another project, see ctl.result; see moved in the issues folder for the new key), "transition", which works like the transition file, and "goto status [--via a,b] [--avoid c,d]", which changes the status like the status file, optionally passing through or avoiding the comma-separated statuses. In the future, more commands may be made available for things that map poorly to files.
+ABC-1/deps: The issues blocking this issue, recursively, rendered as a tree with the status of each issue. Unresolved blockers and cycles are flagged. The followed link type and depth are set with the deps-link and deps-depth variables. deps.dot contains the same graph in the DOT format.
+ABC-1/fixversions, ABC-1/affectsversions: The fix versions and affected versions of the issue. Writable, one version per line. Like components, the version names are case sensitive.
 ABC-1/links: Issue links in the form of "INWARD-ISSUE OUTWARD-ISSUE RELATIONSHIP", such as "ABC-1 ABC-2 Blocks". Writable.
 ABC-1/raw: The raw JSON issue object. Writable. Expects the written data to be JSON, and the write will be pushed as an issue update.
-ABC-1/status: When writing to the status file, jirafs will fetch the relevant workflow graph and trace the shortest path from the current status to the requested status, issuing the necessary transitions in order.
-ABC-1/transition: A list of currently possible transitions. Writing to the file executes the transition. See status for a more convenient way of changing issue status.
+ABC-1/status: When writing to the status file, jirafs will fetch the relevant workflow graph (see workflow-ttl) and trace the cheapest path (see cost in the root help) from the current status to the requested status, issuing the necessary transitions in order.
+ABC-1/status.plan: Writing a target status (optionally followed by --via and --avoid as for the goto command) to status.plan plans the status change without executing it. Reading status.plan returns the planned transitions.
+ABC-1/transition: A list of currently possible transitions. Writing to the file executes the transition. Transition screen fields and a comment can be provided after the transition name, such as: Resolve Issue resolution=Fixed comment="done in 1.4". See status for a more convenient way of changing issue status.
 
 For deeper structural representation under this hierarchy, cat 'structure'.
 `
@@ -843,6 +1789,7 @@ For deeper structural representation under this hierarchy, cat 'structure'.
 	 summary
 	 type
   ABC-1/
+	 affectsversions
 	 assignee
 	 comments/
 		1/
@@ -857,7 +1804,11 @@ For deeper structural representation under this hierarchy, cat 'structure'.
 	 components
 	 creator
 	 ctl
+	 ctl.result
+	 deps
+	 deps.dot
 	 description
+	 fixversions
 	 key
 	 labels
 	 links
@@ -868,6 +1819,7 @@ For deeper structural representation under this hierarchy, cat 'structure'.
 	 reporter
 	 resolution
 	 status
+	 status.plan
 	 summary
 	 transition
 	 type
@@ -911,6 +1863,15 @@ For deeper structural representation under this hierarchy, cat 'structure'.
 	return NewJiraDir(issueKey, 0555|qp.DMDIR, "jira", "jira", jc, iw)
 }
 
+func (aiv *AllIssuesView) Rename(jc *Client, oldname, newname string) error {
+	s := strings.Split(strings.ToUpper(oldname), "-")
+	if len(s) != 2 {
+		return trees.ErrPermissionDenied
+	}
+
+	return RenameIssue(jc, strings.ToUpper(oldname), newname)
+}
+
 func (aiv *AllIssuesView) List(jc *Client) ([]qp.Stat, error) {
 	keys, err := GetKeysForSearch(jc, "", jc.maxlisting)
 	if err != nil {
@@ -920,13 +1881,225 @@ func (aiv *AllIssuesView) List(jc *Client) ([]qp.Stat, error) {
 
 	keys = append(keys, "new")
 	issues := StringsToStats(keys, 0555|qp.DMDIR, "jira", "jira")
-	help := StringsToStats([]string{"help", "structure"}, 055, "jira", "jira")
-	return append(issues, help...), nil
+	help := StringsToStats([]string{"help", "structure", "index", "moved"}, 0555, "jira", "jira")
+	columns := StringsToStats([]string{"columns"}, 0777, "jira", "jira")
+	return append(append(issues, help...), columns...), nil
+}
+
+// FilterView is a search folder backed by a saved JIRA filter. The query of
+// the search is replaced by the jql, name and description files, which update
+// the filter on the server.
+type FilterView struct {
+	id string
+	sw *SearchView
+}
+
+func (fv *FilterView) Walk(jc *Client, file string) (trees.File, error) {
+	switch file {
+	case "query":
+		return nil, nil
+	case "jql", "name", "description":
+	default:
+		return fv.sw.Walk(jc, file)
+	}
+
+	filter, err := GetFilter(jc, fv.id)
+	if err != nil {
+		return nil, err
+	}
+
+	var cnt string
+	switch file {
+	case "jql":
+		cnt = filter.JQL + "\n"
+	case "name":
+		cnt = filter.Name + "\n"
+	case "description":
+		cnt = filter.Description + "\n"
+	}
+
+	sf := trees.NewSyntheticFile(file, 0777, "jira", "jira")
+	sf.SetContent([]byte(cnt))
+
+	onClose := func() error {
+		sf.RLock()
+		str := string(sf.Content)
+		sf.RUnlock()
+
+		// JIRA requires the name in every filter update.
+		fields := map[string]interface{}{
+			"name": filter.Name,
+		}
+		switch file {
+		case "jql":
+			str = strings.TrimSpace(strings.Replace(str, "\n", " ", -1))
+			fields["jql"] = str
+		case "name":
+			fields["name"] = strings.Replace(str, "\n", "", -1)
+		case "description":
+			fields["description"] = strings.TrimRight(str, "\n")
+		}
+
+		if err := UpdateFilter(jc, fv.id, fields); err != nil {
+			return err
+		}
+		if file == "jql" {
+			fv.sw.setQuery(str)
+		}
+		return nil
+	}
+
+	cs := NewCloseSaver(sf, onClose)
+	cs.forceTrunc = true
+	return cs, nil
+}
+
+func (fv *FilterView) List(jc *Client) ([]qp.Stat, error) {
+	stats, err := fv.sw.List(jc)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []qp.Stat
+	for _, st := range stats {
+		if st.Name != "query" {
+			res = append(res, st)
+		}
+	}
+
+	return append(res, StringsToStats([]string{"jql", "name", "description"}, 0777, "jira", "jira")...), nil
+}
+
+// FiltersView lists the favourite filters of the user by name. Creating a
+// folder creates a new filter.
+type FiltersView struct {
+	viewLock sync.Mutex
+	views    map[string]*FilterView
+}
+
+// view returns the view for the filter, keeping the search state across
+// walks.
+func (fw *FiltersView) view(f *Filter) *FilterView {
+	fw.viewLock.Lock()
+	defer fw.viewLock.Unlock()
+	if fw.views == nil {
+		fw.views = make(map[string]*FilterView)
+	}
+
+	fv, exists := fw.views[f.ID]
+	if !exists {
+		fv = &FilterView{id: f.ID, sw: &SearchView{id: "filter:" + f.ID}}
+		fw.views[f.ID] = fv
+	}
+	fv.sw.setQuery(f.JQL)
+	return fv
+}
+
+func (fw *FiltersView) Walk(jc *Client, file string) (trees.File, error) {
+	filters, err := GetFavouriteFilters(jc)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range filters {
+		if filters[i].Name == file {
+			return NewJiraDir(file, 0777|qp.DMDIR, "jira", "jira", jc, fw.view(&filters[i]))
+		}
+	}
+
+	return nil, nil
+}
+
+func (fw *FiltersView) List(jc *Client) ([]qp.Stat, error) {
+	filters, err := GetFavouriteFilters(jc)
+	if err != nil {
+		log.Printf("Could not generate filter list: %v", err)
+		return nil, err
+	}
+
+	var strs []string
+	for _, f := range filters {
+		strs = append(strs, f.Name)
+	}
+
+	return StringsToStats(strs, 0777|qp.DMDIR, "jira", "jira"), nil
+}
+
+func (fw *FiltersView) Create(jc *Client, name string, perms qp.FileMode) (trees.File, error) {
+	if perms&qp.DMDIR == 0 {
+		return nil, trees.ErrPermissionDenied
+	}
+
+	f, err := CreateFilter(jc, name, "ORDER BY updated DESC")
+	if err != nil {
+		return nil, err
+	}
+
+	return NewJiraDir(name, 0777|qp.DMDIR, "jira", "jira", jc, fw.view(f))
+}
+
+// TrashView lists the archives of deleted issues.
+type TrashView struct{}
+
+func (tv *TrashView) Walk(jc *Client, file string) (trees.File, error) {
+	switch file {
+	case "ctl":
+		cmds := map[string]func([]string) error{
+			"restore": func(args []string) error {
+				if len(args) != 1 {
+					return errors.New("invalid arguments")
+				}
+				key := strings.ToUpper(args[0])
+				newKey, notes, err := RestoreIssue(jc, key)
+				res := fmt.Sprintf("%s %s\n", key, newKey)
+				for _, n := range notes {
+					res += n + "\n"
+				}
+				if err != nil {
+					res = fmt.Sprintf("%s error: %v\n", key, err)
+				}
+				jc.results.Set("trash/ctl.result", res)
+				return err
+			},
+		}
+		return NewCommandFile("ctl", 0777, "jira", "jira", cmds), nil
+	case "ctl.result":
+		sf := trees.NewSyntheticFile(file, 0555, "jira", "jira")
+		sf.SetContent([]byte(jc.results.Get("trash/ctl.result")))
+		return sf, nil
+	}
+
+	ti, err := GetTrashedIssue(jc, file)
+	if err != nil {
+		return nil, nil
+	}
+
+	b, err := json.MarshalIndent(ti, "", "	")
+	if err != nil {
+		return nil, err
+	}
+
+	sf := trees.NewSyntheticFile(file, 0555, "jira", "jira")
+	sf.SetContent(b)
+	return sf, nil
+}
+
+func (tv *TrashView) List(jc *Client) ([]qp.Stat, error) {
+	keys, err := GetTrashedIssues(jc)
+	if err != nil {
+		return nil, err
+	}
+
+	a := StringsToStats(keys, 0555, "jira", "jira")
+	b := StringsToStats([]string{"ctl"}, 0777, "jira", "jira")
+	c := StringsToStats([]string{"ctl.result"}, 0555, "jira", "jira")
+	return append(append(a, b...), c...), nil
 }
 
 type JiraView struct {
 	searchLock sync.Mutex
 	searches   map[string]*SearchView
+	filters    FiltersView
 }
 
 func (jw *JiraView) Walk(jc *Client, file string) (trees.File, error) {
@@ -944,7 +2117,7 @@ func (jw *JiraView) Walk(jc *Client, file string) (trees.File, error) {
 					return errors.New("query missing")
 				}
 
-				sw := &SearchView{query: strings.Join(args[1:], " ")}
+				sw := &SearchView{id: "search:" + args[0], query: strings.Join(args[1:], " ")}
 				if err := sw.search(jc); err != nil {
 					return err
 				}
@@ -954,6 +2127,47 @@ func (jw *JiraView) Walk(jc *Client, file string) (trees.File, error) {
 				jw.searchLock.Unlock()
 				return nil
 			},
+			"cost": func(args []string) error {
+				return jc.costs.Set(args)
+			},
+			"undo": func(args []string) error {
+				if len(args) != 1 {
+					return errors.New("invalid arguments")
+				}
+				n, err := strconv.Atoi(args[0])
+				if err != nil {
+					return err
+				}
+				return jc.journal.Undo(jc, n)
+			},
+			"replay": func(args []string) error {
+				return ReplayWrites(jc)
+			},
+			"mirror": func(args []string) error {
+				if len(args) < 1 {
+					return errors.New("invalid arguments")
+				}
+				switch args[0] {
+				case "add":
+					if len(args) < 3 {
+						return errors.New("name or query missing")
+					}
+					return jc.mirror.AddTarget(args[1], strings.Join(args[2:], " "))
+				case "remove":
+					if len(args) != 2 {
+						return errors.New("invalid arguments")
+					}
+					return jc.mirror.RemoveTarget(args[1])
+				case "sync":
+					return SyncMirror(jc)
+				default:
+					return errors.New("unknown mirror command")
+				}
+			},
+			"invalidate-workflows": func(args []string) error {
+				jc.workflows.InvalidateAll()
+				return nil
+			},
 			"pass-login": func(args []string) error {
 				if len(args) == 2 {
 					jc.user = args[0]
@@ -962,7 +2176,7 @@ func (jw *JiraView) Walk(jc *Client, file string) (trees.File, error) {
 				return nil
 			},
 			"set": func(args []string) error {
-				if len(args) != 2 {
+				if len(args) < 2 || (len(args) > 2 && args[0] != "deps-link") {
 					return errors.New("invalid arguments")
 				}
 				switch args[0] {
@@ -973,6 +2187,76 @@ func (jw *JiraView) Walk(jc *Client, file string) (trees.File, error) {
 					}
 					jc.maxlisting = int(mi)
 					return nil
+				case "deps-depth":
+					mi, err := strconv.ParseInt(args[1], 10, 64)
+					if err != nil {
+						return err
+					}
+					jc.SetDepsDepth(int(mi))
+					return nil
+				case "deps-link":
+					jc.SetDepsLink(strings.Join(args[1:], " "))
+					return nil
+				case "bulk-concurrency":
+					mi, err := strconv.ParseInt(args[1], 10, 64)
+					if err != nil {
+						return err
+					}
+					jc.SetBulkConcurrency(int(mi))
+					return nil
+				case "mode":
+					switch args[1] {
+					case "offline":
+						jc.SetOffline(true)
+					case "online":
+						jc.SetOffline(false)
+					default:
+						return errors.New("expected offline or online")
+					}
+					return nil
+				case "mirror-attachments":
+					switch args[1] {
+					case "on":
+						return jc.mirror.SetAttachments(true)
+					case "off":
+						return jc.mirror.SetAttachments(false)
+					default:
+						return errors.New("expected on or off")
+					}
+				case "audit":
+					switch args[1] {
+					case "on":
+						jc.audit.SetEnabled(true)
+					case "off":
+						jc.audit.SetEnabled(false)
+					default:
+						return errors.New("expected on or off")
+					}
+					return nil
+				case "event-interval":
+					interval, err := time.ParseDuration(args[1])
+					if err != nil {
+						return err
+					}
+					if interval <= 0 {
+						return errors.New("interval must be positive")
+					}
+					jc.SetEventInterval(interval)
+					return nil
+				case "delete-window":
+					window, err := time.ParseDuration(args[1])
+					if err != nil {
+						return err
+					}
+					jc.SetDeleteWindow(window)
+					return nil
+				case "workflow-ttl":
+					ttl, err := time.ParseDuration(args[1])
+					if err != nil {
+						return err
+					}
+					jc.workflows.SetTTL(ttl)
+					return nil
 				default:
 					return errors.New("unknown variable")
 				}
@@ -983,18 +2267,88 @@ func (jw *JiraView) Walk(jc *Client, file string) (trees.File, error) {
 		return NewJiraDir(file, 0555|qp.DMDIR, "jira", "jira", jc, &AllProjectsView{})
 	case "issues":
 		return NewJiraDir(file, 0555|qp.DMDIR, "jira", "jira", jc, &AllIssuesView{})
+	case "filters":
+		return NewJiraDir(file, 0777|qp.DMDIR, "jira", "jira", jc, &jw.filters)
+	case "trash":
+		return NewJiraDir(file, 0555|qp.DMDIR, "jira", "jira", jc, &TrashView{})
+	case "journal":
+		sf := trees.NewSyntheticFile(file, 0555, "jira", "jira")
+		sf.SetContent([]byte(jc.journal.String()))
+		return sf, nil
+	case "log":
+		sf := trees.NewSyntheticFile(file, 0555, "jira", "jira")
+		sf.SetContent([]byte(jc.audit.String()))
+		return sf, nil
+	case "events":
+		return NewEventFile(file, jc, ""), nil
+	case "mirror":
+		sf := trees.NewSyntheticFile(file, 0555, "jira", "jira")
+		sf.SetContent([]byte(jc.mirror.String()))
+		return sf, nil
+	case "pending":
+		sf := trees.NewSyntheticFile(file, 0555, "jira", "jira")
+		sf.SetContent([]byte(jc.queue.String()))
+		return sf, nil
+	case "stats":
+		sf := trees.NewSyntheticFile(file, 0555, "jira", "jira")
+		sf.SetContent([]byte(jc.metrics.String()))
+		return sf, nil
 	case "structure":
 		message := `
 /
 	ctl
+	events
+	journal
+	log
+	mirror
+	pending
+	stats
+	filters/
+	  My open issues/
+		 ...
+	  ...
+	trash/
+	  ctl
+	  ctl.result
+	  ABC-3
+	  ...
 	projects/
 	  ABC/
-		 components
+		 ctl
+		 ctl.result
+		 components/
+			backend/
+				assignee
+				assigneetype
+				description
+				issues/
+				lead
+			...
+		 epics/
+			ABC-7/
+				childkeys
+				children/
+				...
+			...
 		 issuetypes
 		 issues/
 			1/ # ABC-1
 				...
 			...
+		 versions/
+			ctl
+			1.0/
+				description
+				issues/
+				released
+				releasedate
+			...
+		 workflows/
+			Bug/
+				graph.dot
+				statuses
+				transitions
+			...
 
 	  DEF/
 		 ...
@@ -1007,6 +2361,7 @@ func (jw *JiraView) Walk(jc *Client, file string) (trees.File, error) {
 		 summary
 		 type
 	  ABC-1/
+		 affectsversions
 		 assignee
 		 comments/
 			1/
@@ -1021,7 +2376,11 @@ func (jw *JiraView) Walk(jc *Client, file string) (trees.File, error) {
 		 components
 		 creator
 		 ctl
+		 ctl.result
+		 deps
+		 deps.dot
 		 description
+		 fixversions
 		 key
 		 labels
 		 links
@@ -1032,6 +2391,7 @@ func (jw *JiraView) Walk(jc *Client, file string) (trees.File, error) {
 		 reporter
 		 resolution
 		 status
+		 status.plan
 		 summary
 		 transition
 		 type
@@ -1052,12 +2412,40 @@ func (jw *JiraView) Walk(jc *Client, file string) (trees.File, error) {
 	case "help":
 		message := `ctl: A global control file. It supports the following commands:
 	* search search_name JQL
-		If successful, a folder named search_name will appear at the jirafs root. ls'ing in the folder updates the search. The search does not update when simply trying to access an issue in order to avoid significant performance issues.
+		If successful, a folder named search_name will appear at the jirafs root. ls'ing in the folder updates the search. The search does not update when simply trying to access an issue in order to avoid significant performance issues. The folder contains a ctl file for bulk commands on all matching issues (set field value, assign user, label +add -remove, transition name [field=value ...], comment text) and git exports (git-export), a report file with the per-issue results of the last bulk command, an events file returning a line per change to the matching issues, writable query, order (such as "updated DESC") and limit files that take effect on the next listing, and read-only count and lastrun files describing the last listing.
 	* pass-login
 		Re-issue a username/password login using the initially provided credentials.
 	* set name val
-		Sets jirafs variables. Currently, max-listing is the only variable, which expects an integer.
+		Sets jirafs variables. Currently, the following variables are supported:
+			max-listing: the max directory listing length, which expects an integer.
+			deps-link: the issue link type followed by deps files, such as "Blocks".
+			deps-depth: the max depth followed by deps files, which expects an integer.
+			bulk-concurrency: the max number of concurrent requests made by bulk commands in search folders.
+			delete-window: how long an armed issue deletion can be confirmed, such as "1m".
+			event-interval: how often events files poll for changes, such as "30s". Applies to open events files from their next poll.
+			audit: "on" or "off", toggling the audit log.
+			mode: "offline" or "online". In offline mode, reads are served from the mirror and writes are queued.
+			mirror-attachments: "on" or "off", toggling whether attachments are mirrored.
+			workflow-ttl: how long workflow graphs are cached, such as "1h". 0 caches forever.
+	* undo n
+		Reverts entry n of the journal, by issuing the recorded revert call, or otherwise by changing the status back, deleting created issues or restoring deleted issues from the trash. The revert is itself recorded in the journal.
+	* replay
+		Replays the writes queued while JIRA was unreachable. Queued writes are also retried periodically.
+	* mirror add name query|PROJECT, mirror remove name, mirror sync
+		Adds or removes a JQL query or project to mirror locally, or syncs the mirror now. The mirror is also synced periodically.
+	* invalidate-workflows
+		Drops all cached workflow graphs.
+	* cost transition|status cost name
+		Sets the cost of passing through a transition or status when changing status. All transitions have a base cost of 1, and the cheapest path is used.
+filters/: Directory listing of favourite filters, as search folders. The jql, name and description files of each filter are writable and update the filter. Creating a folder creates a new filter.
 projects/: Directory listing of projects.
+events: Blocks on read until issues change, returning a line per change, such as "ABC-1 status In Progress -> Done alice". Search folders have an events file for their matching issues as well.
+journal: The mutations made through jirafs, as JSON lines with the entry number, time, 9P user, operation, issue, field, old and new value, and the REST call reverting the mutation.
+log: The audit log, as JSON lines of 9P operations (user, path, op) and REST calls (method, url, status), with their latency. Enabled with "set audit on".
+mirror: The mirrored queries, one per line, with their name, last sync, its result and query. Issues, listings and indexes are read from the mirror in offline mode, or when JIRA cannot be reached.
+pending: Writes of fields, comments and transitions that failed because JIRA could not be reached, as JSON lines. They are replayed in order once JIRA can be reached, on behalf of the user that made them. Queued writes, conflicts and failed replays are noted in the journal.
+stats: Metrics in the Prometheus text format: REST calls by endpoint and status, their latency, cache lookups, active 9P connections, open handles and failed writes by field. Also served over HTTP at /metrics with the -metrics flag.
+trash/: Archives of deleted issues, by key. Writing "restore ABC-1" to trash/ctl recreates the issue from its archive, with the new key in trash/ctl.result.
 issues/: Directory listing of issues
 
 For deeper structural representation, cat 'structure'
@@ -1088,16 +2476,16 @@ func (jw *JiraView) List(jc *Client) ([]qp.Stat, error) {
 		strs = append(strs, k)
 	}
 
-	a := StringsToStats([]string{"projects", "issues"}, 0555|qp.DMDIR, "jira", "jira")
+	a := StringsToStats([]string{"projects", "issues", "trash"}, 0555|qp.DMDIR, "jira", "jira")
 	b := StringsToStats([]string{"ctl"}, 0777, "jira", "jira")
-	c := StringsToStats([]string{"help", "structure"}, 0555, "jira", "jira")
-	d := StringsToStats(strs, 0777|qp.DMDIR, "jira", "jira")
+	c := StringsToStats([]string{"help", "structure", "events", "journal", "log", "mirror", "pending", "stats"}, 0555, "jira", "jira")
+	d := StringsToStats(append(strs, "filters"), 0777|qp.DMDIR, "jira", "jira")
 	return append(append(append(a, b...), c...), d...), nil
 }
 
 func (jw *JiraView) Remove(jc *Client, file string) error {
 	switch file {
-	case "ctl", "projects", "issues", "structure", "help":
+	case "ctl", "projects", "issues", "filters", "trash", "events", "journal", "log", "mirror", "pending", "stats", "structure", "help":
 		return trees.ErrPermissionDenied
 	default:
 		jw.searchLock.Lock()
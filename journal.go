@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JournalEntry records a single mutation made through jirafs.
+type JournalEntry struct {
+	N    int       `json:"n"`
+	Time time.Time `json:"time"`
+	User string    `json:"user"`
+
+	// Op is the kind of mutation, such as "set", "transition", "create",
+	// "delete", "link", "unlink", "labels", "merge" or "undo".
+	Op string `json:"op"`
+
+	// Issue is the mutated issue, or the mutated version, component or
+	// filter, such as "version/10001".
+	Issue string `json:"issue"`
+
+	// Field is the mutated field, or the mutated part of the issue, such
+	// as "comments/10001".
+	Field string `json:"field,omitempty"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+
+	// Revert is the REST call reverting the mutation, if it can be
+	// reverted by a single call.
+	Revert *Revert `json:"revert,omitempty"`
+
+	// Undo is the entry reverted by this entry, if any.
+	Undo int `json:"undo,omitempty"`
+
+	// Note marks entries that report on queued writes rather than record
+	// mutations, such as replay conflicts.
+	Note string `json:"note,omitempty"`
+}
+
+// Revert is a REST call reverting a mutation.
+type Revert struct {
+	Method string          `json:"method"`
+	URL    string          `json:"url"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// newRevert returns the REST call reverting a mutation, with body encoded as
+// JSON. It returns nil if body cannot be encoded.
+func newRevert(method, url string, body interface{}) *Revert {
+	r := &Revert{Method: method, URL: url}
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil
+		}
+		r.Body = b
+	}
+	return r
+}
+
+// journalMutation records a mutation made by the client in the journal, on
+// behalf of the 9P user of the client.
+func journalMutation(jc *Client, e JournalEntry) {
+	e.User = jc.User()
+	if _, err := jc.journal.Append(e); err != nil {
+		log.Printf("Could not record %s of %s in journal: %v", e.Op, e.Issue, err)
+	}
+}
+
+// journalValue renders a raw JSON field value for the journal: strings as
+// is, objects by name, arrays one element per line, and anything else as
+// JSON.
+func journalValue(raw json.RawMessage) string {
+	var str string
+	if json.Unmarshal(raw, &str) == nil {
+		return str
+	}
+
+	var named struct {
+		Name string `json:"name"`
+	}
+	if json.Unmarshal(raw, &named) == nil && named.Name != "" {
+		return named.Name
+	}
+
+	var list []json.RawMessage
+	if json.Unmarshal(raw, &list) == nil {
+		var b bytes.Buffer
+		for _, v := range list {
+			b.WriteString(journalValue(v) + "\n")
+		}
+		return b.String()
+	}
+
+	if string(raw) == "null" {
+		return ""
+	}
+	return string(raw)
+}
+
+// Journal is an append-only record of mutations. If a file is set, entries
+// are also appended to it as JSON lines. The zero value is an in-memory
+// journal.
+type Journal struct {
+	sync.Mutex
+	file    string
+	entries []JournalEntry
+}
+
+// Load reads the existing entries from the file, and appends new entries to
+// it from then on.
+func (j *Journal) Load(file string) error {
+	j.Lock()
+	defer j.Unlock()
+	j.file = file
+
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var e JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("could not parse journal: %v", err)
+		}
+		j.entries = append(j.entries, e)
+	}
+	return scanner.Err()
+}
+
+// Append numbers and records the entry.
+func (j *Journal) Append(e JournalEntry) (JournalEntry, error) {
+	j.Lock()
+	defer j.Unlock()
+
+	e.N = len(j.entries) + 1
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	if j.file != "" {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return e, err
+		}
+		f, err := os.OpenFile(j.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return e, err
+		}
+		_, err = f.Write(append(b, '\n'))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return e, fmt.Errorf("could not write journal: %v", err)
+		}
+	}
+
+	j.entries = append(j.entries, e)
+	return e, nil
+}
+
+func (j *Journal) Get(n int) (JournalEntry, bool) {
+	j.Lock()
+	defer j.Unlock()
+	if n < 1 || n > len(j.entries) {
+		return JournalEntry{}, false
+	}
+	return j.entries[n-1], true
+}
+
+// String renders the journal as JSON lines.
+func (j *Journal) String() string {
+	j.Lock()
+	defer j.Unlock()
+
+	var b bytes.Buffer
+	for _, e := range j.entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Undo reverts the journal entry, and records the revert as a new entry on
+// behalf of the 9P user of the client. Mutations are reverted by the REST
+// call recorded in the entry, if any. Otherwise, transitions are reverted by
+// changing the status back, created issues are deleted to the trash, and
+// deleted issues are restored from the trash.
+func (j *Journal) Undo(jc *Client, n int) error {
+	e, ok := j.Get(n)
+	if !ok {
+		return errors.New("no such journal entry")
+	}
+	if e.Note != "" {
+		return errors.New("cannot undo reports")
+	}
+
+	// Versions, components and filters are journaled as "kind/id".
+	isIssue := e.Field == "" && !strings.Contains(e.Issue, "/")
+
+	switch {
+	case e.Revert != nil:
+		var body interface{}
+		if len(e.Revert.Body) > 0 {
+			body = []byte(e.Revert.Body)
+		}
+		if err := jc.RPC(e.Revert.Method, e.Revert.URL, body, nil); err != nil {
+			return fmt.Errorf("could not undo entry %d: %v", n, err)
+		}
+	case e.Op == "transition":
+		if err := ChangeStatus(jc, e.Issue, e.Old, nil, nil); err != nil {
+			return err
+		}
+	case e.Op == "create" && isIssue:
+		if err := SafeDeleteIssue(jc, e.Issue); err != nil {
+			return err
+		}
+	case e.Op == "delete" && isIssue:
+		if _, _, err := RestoreIssue(jc, e.Issue); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("cannot undo %s of %s", e.Op, e.Issue)
+	}
+
+	journalMutation(jc, JournalEntry{
+		Op:    "undo",
+		Issue: e.Issue,
+		Field: e.Field,
+		Old:   e.New,
+		New:   e.Old,
+		Undo:  e.N,
+	})
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestJournalUndo(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jc := &Client{clientState: &clientState{Client: srv.Client(), jiraURL: u}}
+
+	entries := []JournalEntry{
+		{Op: "create", Issue: "version/10001", New: "1.0", Revert: newRevert("DELETE", "/rest/api/2/version/10001", nil)},
+		{Op: "delete", Issue: "component/10002", Old: `{"name":"Backend"}`},
+		{Op: "set", Issue: "filter/10003", Field: "jql", Old: "project = ABC", New: "project = DEF",
+			Revert: newRevert("PUT", "/rest/api/2/filter/10003", map[string]string{"jql": "project = ABC"})},
+		{Op: "set", Issue: "ABC-1", Field: "summary", Note: "queued as write 1"},
+		{Op: "set", Issue: "ABC-1", Field: "summary"},
+	}
+	for _, e := range entries {
+		if _, err := jc.journal.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		n        int
+		err      bool
+		requests []string
+	}{
+		{1, false, []string{"DELETE /rest/api/2/version/10001"}},
+		{2, true, nil},
+		{3, false, []string{"PUT /rest/api/2/filter/10003"}},
+		{4, true, nil},
+		{5, true, nil},
+		{99, true, nil},
+	}
+
+	for _, tt := range tests {
+		requests = nil
+		before := len(jc.journal.entries)
+		err := jc.journal.Undo(jc, tt.n)
+		if (err != nil) != tt.err {
+			t.Errorf("Undo(%d): err = %v, want error %v", tt.n, err, tt.err)
+			continue
+		}
+		if !reflect.DeepEqual(requests, tt.requests) {
+			t.Errorf("Undo(%d): requests = %q, want %q", tt.n, requests, tt.requests)
+		}
+
+		if tt.err {
+			if len(jc.journal.entries) != before {
+				t.Errorf("Undo(%d): failed undo was journaled", tt.n)
+			}
+			continue
+		}
+		last, _ := jc.journal.Get(len(jc.journal.entries))
+		if last.Op != "undo" || last.Undo != tt.n {
+			t.Errorf("Undo(%d): last entry = %+v, want undo of %d", tt.n, last, tt.n)
+		}
+	}
+}
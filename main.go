@@ -6,6 +6,9 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/howeyc/gopass"
 	"github.com/joushou/qp"
@@ -20,6 +23,18 @@ var (
 	pass       = flag.Bool("pass", false, "use password for authorization")
 	jiraURLStr = flag.String("url", "", "jira URL")
 	maxlisting = flag.Int("maxlisting", 100, "max directory listing length")
+	depsLink   = flag.String("depslink", "Blocks", "issue link type followed by deps files")
+	depsDepth  = flag.Int("depsdepth", 5, "max depth followed by deps files")
+	wfTTL      = flag.Duration("workflowttl", time.Hour, "how long workflow graphs are cached")
+	costsFile  = flag.String("costs", "", "file with transition and status costs")
+	metrics    = flag.String("metrics", "", "address to serve Prometheus metrics on, such as :9090")
+	audit      = flag.Bool("audit", false, "enable the audit log")
+	mirror     = flag.String("mirror", "", "directory of the local mirror (default $HOME/.jirafs/mirror)")
+	syncEvery  = flag.Duration("syncinterval", defaultSyncInterval, "how often the mirror is synced, 0 to only sync on request")
+	gitDir     = flag.String("git", "", "git repository for git exports, also exporting on mirror syncs")
+	queue      = flag.String("queue", "", "file for writes queued while JIRA is unreachable (default $HOME/.jirafs/pending)")
+	journal    = flag.String("journal", "", "file for the write journal (default $HOME/.jirafs/journal)")
+	trashDir   = flag.String("trash", "", "directory for archives of deleted issues (default $HOME/.jirafs/trash)")
 )
 
 func main() {
@@ -31,11 +46,55 @@ func main() {
 		return
 	}
 
-	client := &Client{
-		Client:     &http.Client{},
-		usingOAuth: *usingOAuth,
-		jiraURL:    jiraURL,
-		maxlisting: *maxlisting,
+	if *journal == "" {
+		*journal = filepath.Join(os.Getenv("HOME"), ".jirafs", "journal")
+	}
+	if *mirror == "" {
+		*mirror = filepath.Join(os.Getenv("HOME"), ".jirafs", "mirror")
+	}
+	if *queue == "" {
+		*queue = filepath.Join(os.Getenv("HOME"), ".jirafs", "pending")
+	}
+	if *trashDir == "" {
+		*trashDir = filepath.Join(os.Getenv("HOME"), ".jirafs", "trash")
+	}
+
+	client := &Client{clientState: &clientState{
+		Client:        &http.Client{},
+		usingOAuth:    *usingOAuth,
+		jiraURL:       jiraURL,
+		maxlisting:    *maxlisting,
+		depsLink:      *depsLink,
+		depsDepth:     *depsDepth,
+		trashDir:      *trashDir,
+		deleteWindow:  defaultDeleteWindow,
+		eventInterval: defaultEventInterval,
+		gitDir:        *gitDir,
+	}}
+	client.workflows.SetTTL(*wfTTL)
+
+	if *costsFile != "" {
+		if err := client.costs.Load(*costsFile); err != nil {
+			fmt.Printf("Could not load costs: %v\n", err)
+			return
+		}
+	}
+
+	client.audit.SetEnabled(*audit)
+
+	if err := client.journal.Load(*journal); err != nil {
+		fmt.Printf("Could not load journal: %v\n", err)
+		return
+	}
+
+	if err := client.queue.Load(*queue); err != nil {
+		fmt.Printf("Could not load write queue: %v\n", err)
+		return
+	}
+
+	if err := client.mirror.Load(*mirror); err != nil {
+		fmt.Printf("Could not load mirror: %v\n", err)
+		return
 	}
 
 	switch {
@@ -71,6 +130,19 @@ func main() {
 		return
 	}
 
+	if *metrics != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", &client.metrics)
+		go func() {
+			if err := http.ListenAndServe(*metrics, mux); err != nil {
+				fmt.Printf("Could not serve metrics: %v\n", err)
+			}
+		}()
+	}
+
+	go RunReplays(client)
+	go RunSyncs(client, *syncEvery)
+
 	l, err := net.Listen("tcp", *address)
 	if err != nil {
 		fmt.Printf("Could not listen: %v\n", err)
@@ -86,7 +158,11 @@ func main() {
 
 		f := fileserver.New(conn, root, nil)
 		f.Verbosity = fileserver.Quiet
-		go f.Serve()
+		go func() {
+			client.metrics.Conns(1)
+			defer client.metrics.Conns(-1)
+			f.Serve()
+		}()
 	}
 
 }
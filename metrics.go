@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joushou/qptools/fileserver/trees"
+)
+
+// latencyBuckets are the upper bounds of the REST latency histogram, in
+// seconds.
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var (
+	endpointKey = regexp.MustCompile(`^[A-Z][A-Z0-9_]*-[0-9]+$`)
+	endpointID  = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// Endpoint returns the path of a REST call with issue keys and numeric ids
+// replaced by placeholders, such as "/rest/api/2/issue/{key}/comment/{id}".
+func Endpoint(path string) string {
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		switch {
+		case endpointKey.MatchString(p):
+			parts[i] = "{key}"
+		case endpointID.MatchString(p):
+			parts[i] = "{id}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	for i, b := range latencyBuckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+type requestLabels struct {
+	method, endpoint, status string
+}
+
+type endpointLabels struct {
+	method, endpoint string
+}
+
+type cacheLabels struct {
+	cache, result string
+}
+
+// Metrics counts REST calls, cache lookups, connections, open handles and
+// failed writes. The zero value is ready to use.
+type Metrics struct {
+	sync.Mutex
+	requests     map[requestLabels]uint64
+	latency      map[endpointLabels]*histogram
+	cache        map[cacheLabels]uint64
+	failedWrites map[string]uint64
+	conns        int64
+	handles      int64
+}
+
+// Request records a REST call. Status is 0 if no response was received.
+func (m *Metrics) Request(method, path string, status int, d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	if m.requests == nil {
+		m.requests = make(map[requestLabels]uint64)
+		m.latency = make(map[endpointLabels]*histogram)
+	}
+
+	s := "error"
+	if status != 0 {
+		s = strconv.Itoa(status)
+	}
+	endpoint := Endpoint(path)
+	m.requests[requestLabels{method, endpoint, s}]++
+
+	el := endpointLabels{method, endpoint}
+	h := m.latency[el]
+	if h == nil {
+		h = &histogram{}
+		m.latency[el] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// CacheLookup records a lookup in the named cache.
+func (m *Metrics) CacheLookup(cache string, hit bool) {
+	m.Lock()
+	defer m.Unlock()
+	if m.cache == nil {
+		m.cache = make(map[cacheLabels]uint64)
+	}
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.cache[cacheLabels{cache, result}]++
+}
+
+func (m *Metrics) FailedWrite(field string) {
+	m.Lock()
+	defer m.Unlock()
+	if m.failedWrites == nil {
+		m.failedWrites = make(map[string]uint64)
+	}
+	m.failedWrites[field]++
+}
+
+// Conns adjusts the number of active 9P connections.
+func (m *Metrics) Conns(delta int64) {
+	m.Lock()
+	defer m.Unlock()
+	m.conns += delta
+}
+
+// Handles adjusts the number of open file handles.
+func (m *Metrics) Handles(delta int64) {
+	m.Lock()
+	defer m.Unlock()
+	m.handles += delta
+}
+
+func quoteLabel(s string) string {
+	return strconv.Quote(s)
+}
+
+// String renders the metrics in the Prometheus text format.
+func (m *Metrics) String() string {
+	m.Lock()
+	defer m.Unlock()
+
+	var lines []string
+	add := func(s ...string) { lines = append(lines, s...) }
+
+	add("# HELP jirafs_rest_requests_total REST calls made to JIRA.",
+		"# TYPE jirafs_rest_requests_total counter")
+	var rs []string
+	for l, v := range m.requests {
+		rs = append(rs, fmt.Sprintf("jirafs_rest_requests_total{method=%s,endpoint=%s,status=%s} %d",
+			quoteLabel(l.method), quoteLabel(l.endpoint), quoteLabel(l.status), v))
+	}
+	sort.Strings(rs)
+	add(rs...)
+
+	add("# HELP jirafs_rest_request_duration_seconds Latency of REST calls made to JIRA.",
+		"# TYPE jirafs_rest_request_duration_seconds histogram")
+	var els []endpointLabels
+	for l := range m.latency {
+		els = append(els, l)
+	}
+	sort.Slice(els, func(i, j int) bool {
+		if els[i].endpoint != els[j].endpoint {
+			return els[i].endpoint < els[j].endpoint
+		}
+		return els[i].method < els[j].method
+	})
+	for _, l := range els {
+		h := m.latency[l]
+		labels := fmt.Sprintf("method=%s,endpoint=%s", quoteLabel(l.method), quoteLabel(l.endpoint))
+		for i, b := range latencyBuckets {
+			add(fmt.Sprintf("jirafs_rest_request_duration_seconds_bucket{%s,le=\"%g\"} %d", labels, b, h.counts[i]))
+		}
+		add(fmt.Sprintf("jirafs_rest_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d", labels, h.count),
+			fmt.Sprintf("jirafs_rest_request_duration_seconds_sum{%s} %g", labels, h.sum),
+			fmt.Sprintf("jirafs_rest_request_duration_seconds_count{%s} %d", labels, h.count))
+	}
+
+	add("# HELP jirafs_cache_lookups_total Cache lookups, by cache and result.",
+		"# TYPE jirafs_cache_lookups_total counter")
+	var cs []string
+	for l, v := range m.cache {
+		cs = append(cs, fmt.Sprintf("jirafs_cache_lookups_total{cache=%s,result=%s} %d", quoteLabel(l.cache), quoteLabel(l.result), v))
+	}
+	sort.Strings(cs)
+	add(cs...)
+
+	add("# HELP jirafs_failed_writes_total Failed file writes, by field.",
+		"# TYPE jirafs_failed_writes_total counter")
+	var fs []string
+	for f, v := range m.failedWrites {
+		fs = append(fs, fmt.Sprintf("jirafs_failed_writes_total{field=%s} %d", quoteLabel(f), v))
+	}
+	sort.Strings(fs)
+	add(fs...)
+
+	add("# HELP jirafs_connections Active 9P connections.",
+		"# TYPE jirafs_connections gauge",
+		fmt.Sprintf("jirafs_connections %d", m.conns),
+		"# HELP jirafs_open_handles Open handles of directories, writable files and ctl files.",
+		"# TYPE jirafs_open_handles gauge",
+		fmt.Sprintf("jirafs_open_handles %d", m.handles))
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprint(w, m.String())
+}
+
+// countedHandle counts itself as an open handle until closed.
+type countedHandle struct {
+	once sync.Once
+	m    *Metrics
+	trees.ReadWriteAtCloser
+}
+
+func (ch *countedHandle) Close() error {
+	ch.once.Do(func() { ch.m.Handles(-1) })
+	return ch.ReadWriteAtCloser.Close()
+}
+
+func newCountedHandle(m *Metrics, h trees.ReadWriteAtCloser) *countedHandle {
+	m.Handles(1)
+	return &countedHandle{m: m, ReadWriteAtCloser: h}
+}
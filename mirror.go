@@ -0,0 +1,544 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+)
+
+const defaultSyncInterval = 15 * time.Minute
+
+var errNotMirrored = errors.New("not available in the mirror")
+
+// MirrorTarget is a query whose issues are mirrored.
+type MirrorTarget struct {
+	Name     string    `json:"name"`
+	Query    string    `json:"query"`
+	LastSync time.Time `json:"lastSync"`
+	Result   string    `json:"result"`
+}
+
+type mirrorState struct {
+	Targets     []*MirrorTarget `json:"targets"`
+	Attachments bool            `json:"attachments"`
+
+	// Listings holds the last known keys of queries, by query.
+	Listings map[string][]string `json:"listings"`
+}
+
+// Mirror is a local store of the issues matching the mirror targets, with
+// their comments, worklogs and optionally attachments. Each issue is stored
+// in its own directory, as returned by the REST API.
+type Mirror struct {
+	sync.Mutex
+	dir   string
+	state mirrorState
+
+	// syncLock serializes syncs.
+	syncLock sync.Mutex
+}
+
+func (m *Mirror) Load(dir string) error {
+	m.Lock()
+	defer m.Unlock()
+	m.dir = dir
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "state.json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, &m.state); err != nil {
+		return fmt.Errorf("could not parse mirror state: %v", err)
+	}
+	return nil
+}
+
+// save persists the state. The lock must be held.
+func (m *Mirror) save() error {
+	if m.dir == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(m.state, "", "	")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.dir, 0700); err != nil {
+		return err
+	}
+
+	file := filepath.Join(m.dir, "state.json")
+	if err := ioutil.WriteFile(file+".tmp", b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+// AddTarget adds a query to mirror. A query that is a single word is taken
+// to be a project key.
+func (m *Mirror) AddTarget(name, query string) error {
+	if !strings.ContainsAny(query, " =") {
+		query = fmt.Sprintf("project = %s", strings.ToUpper(query))
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	for _, t := range m.state.Targets {
+		if t.Name == name {
+			return errors.New("target already exists")
+		}
+	}
+	m.state.Targets = append(m.state.Targets, &MirrorTarget{Name: name, Query: query})
+	return m.save()
+}
+
+// RemoveTarget stops mirroring a query. Issues already mirrored are kept,
+// but the listing of the query is dropped unless another target mirrors it.
+func (m *Mirror) RemoveTarget(name string) error {
+	m.Lock()
+	defer m.Unlock()
+	for i, t := range m.state.Targets {
+		if t.Name != name {
+			continue
+		}
+		m.state.Targets = append(m.state.Targets[:i], m.state.Targets[i+1:]...)
+
+		shared := false
+		for _, other := range m.state.Targets {
+			if other.Query == t.Query {
+				shared = true
+			}
+		}
+		if !shared {
+			delete(m.state.Listings, t.Query)
+		}
+		return m.save()
+	}
+	return errors.New("no such target")
+}
+
+func (m *Mirror) SetAttachments(attachments bool) error {
+	m.Lock()
+	defer m.Unlock()
+	m.state.Attachments = attachments
+	return m.save()
+}
+
+func (m *Mirror) Targets() []MirrorTarget {
+	m.Lock()
+	defer m.Unlock()
+	var ts []MirrorTarget
+	for _, t := range m.state.Targets {
+		ts = append(ts, *t)
+	}
+	return ts
+}
+
+// String renders the targets, one per line, with their last sync and its
+// result.
+func (m *Mirror) String() string {
+	var s string
+	for _, t := range m.Targets() {
+		last := "never"
+		if !t.LastSync.IsZero() {
+			last = t.LastSync.Format(time.RFC3339)
+		}
+		s += fmt.Sprintf("%s\t%s\t%s\t%s\n", t.Name, last, t.Result, t.Query)
+	}
+	return s
+}
+
+func (m *Mirror) issueDir(key string) string {
+	return filepath.Join(m.dir, "issues", key)
+}
+
+func (m *Mirror) read(key, file string, target interface{}) error {
+	m.Lock()
+	dir := m.issueDir(key)
+	m.Unlock()
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if os.IsNotExist(err) {
+		return errNotMirrored
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, target)
+}
+
+func (m *Mirror) Issue(key string) (*jira.Issue, error) {
+	var i jira.Issue
+	if err := m.read(key, "issue.json", &i); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+func (m *Mirror) Comments(key string) (*CommentResult, error) {
+	var cr CommentResult
+	if err := m.read(key, "comments.json", &cr); err != nil {
+		return nil, err
+	}
+	return &cr, nil
+}
+
+func (m *Mirror) Comment(key, id string) (*jira.Comment, error) {
+	cr, err := m.Comments(key)
+	if err != nil {
+		return nil, err
+	}
+	for i := range cr.Comments {
+		if cr.Comments[i].ID == id {
+			return &cr.Comments[i], nil
+		}
+	}
+	return nil, errNotMirrored
+}
+
+func (m *Mirror) Worklog(key string) (*jira.Worklog, error) {
+	var w jira.Worklog
+	if err := m.read(key, "worklog.json", &w); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (m *Mirror) WorklogRecord(key, id string) (*jira.WorklogRecord, error) {
+	w, err := m.Worklog(key)
+	if err != nil {
+		return nil, err
+	}
+	for i := range w.Worklogs {
+		if w.Worklogs[i].ID == id {
+			return &w.Worklogs[i], nil
+		}
+	}
+	return nil, errNotMirrored
+}
+
+// mirroredKeys returns the keys of the mirrored issues that matched a target
+// query in its last sync, newest first within each project. Issues that no
+// longer match any target are kept in the mirror, but not listed.
+func (m *Mirror) mirroredKeys() ([]string, error) {
+	m.Lock()
+	dir := filepath.Join(m.dir, "issues")
+	listed := make(map[string]bool)
+	for _, t := range m.state.Targets {
+		for _, k := range m.state.Listings[t.Query] {
+			listed[k] = true
+		}
+	}
+	m.Unlock()
+
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, f := range files {
+		if f.IsDir() && listed[f.Name()] {
+			keys = append(keys, f.Name())
+		}
+	}
+	sortKeys(keys)
+	return keys, nil
+}
+
+// sortKeys sorts issue keys by project, and by descending number within
+// each project.
+func sortKeys(keys []string) {
+	sort.Slice(keys, func(i, j int) bool {
+		pi, ni := splitKey(keys[i])
+		pj, nj := splitKey(keys[j])
+		if pi != pj {
+			return pi < pj
+		}
+		return ni > nj
+	})
+}
+
+func splitKey(key string) (string, int) {
+	idx := strings.LastIndex(key, "-")
+	if idx == -1 {
+		return key, 0
+	}
+	n, _ := strconv.Atoi(key[idx+1:])
+	return key[:idx], n
+}
+
+var projectQuery = regexp.MustCompile(`^\s*project\s*=\s*"?([A-Za-z0-9_]+)"?\s*$`)
+
+// Keys returns at most max keys of the mirrored issues matching the query,
+// as well as the total number of matches. Only the empty query, queries
+// of the form "project = ABC" and the queries of targets, as of their last
+// sync, can be answered.
+func (m *Mirror) Keys(query string, max int) ([]string, int, error) {
+	m.Lock()
+	listing, known := m.state.Listings[query]
+	m.Unlock()
+
+	var keys []string
+	switch {
+	case known:
+		keys = append(keys, listing...)
+	case strings.TrimSpace(query) == "":
+		var err error
+		if keys, err = m.mirroredKeys(); err != nil {
+			return nil, 0, err
+		}
+	case projectQuery.MatchString(query):
+		project := strings.ToUpper(projectQuery.FindStringSubmatch(query)[1])
+		all, err := m.mirroredKeys()
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, k := range all {
+			if p, _ := splitKey(k); p == project {
+				keys = append(keys, k)
+			}
+		}
+	default:
+		return nil, 0, fmt.Errorf("query %q %v", query, errNotMirrored)
+	}
+
+	total := len(keys)
+	if max >= 0 && len(keys) > max {
+		keys = keys[:max]
+	}
+	return keys, total, nil
+}
+
+// Search returns at most max mirrored issues matching the query, in the form
+// of a search result.
+func (m *Mirror) Search(query string, max int) (*RawSearchResult, error) {
+	keys, total, err := m.Keys(query, max)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &RawSearchResult{MaxResults: max, Total: total}
+	for _, k := range keys {
+		var ri RawIssue
+		if err := m.read(k, "issue.json", &ri); err != nil {
+			continue
+		}
+		s.Issues = append(s.Issues, ri)
+	}
+	return s, nil
+}
+
+// Projects returns the projects of the mirrored issues.
+func (m *Mirror) Projects() ([]jira.Project, error) {
+	keys, err := m.mirroredKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	var projects []jira.Project
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		p, _ := splitKey(k)
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		projects = append(projects, jira.Project{Key: p})
+	}
+	return projects, nil
+}
+
+type mirrorAttachment struct {
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	Content  string `json:"content"`
+}
+
+// mirrorIssue stores the issue, its comments, worklogs and optionally its
+// attachments in the mirror.
+func mirrorIssue(jc *Client, key string, attachments bool) error {
+	dir := jc.mirror.issueDir(key)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	files := []struct{ file, url string }{
+		{"issue.json", fmt.Sprintf("/rest/api/2/issue/%s", key)},
+		{"comments.json", fmt.Sprintf("/rest/api/2/issue/%s/comment?maxResults=1000", key)},
+		{"worklog.json", fmt.Sprintf("/rest/api/2/issue/%s/worklog", key)},
+	}
+	for _, f := range files {
+		var b []byte
+		if err := jc.RPC("GET", f.url, nil, &b); err != nil {
+			return fmt.Errorf("could not mirror %s: %v", f.file, err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, f.file), b, 0600); err != nil {
+			return err
+		}
+	}
+
+	if !attachments {
+		return nil
+	}
+
+	var issue struct {
+		Fields struct {
+			Attachment []mirrorAttachment `json:"attachment"`
+		} `json:"fields"`
+	}
+	if err := jc.mirror.read(key, "issue.json", &issue); err != nil {
+		return err
+	}
+
+	adir := filepath.Join(dir, "attachments")
+	for _, a := range issue.Fields.Attachment {
+		file := filepath.Join(adir, a.ID+"-"+filepath.Base(a.Filename))
+		if _, err := os.Stat(file); err == nil {
+			continue
+		}
+
+		var b []byte
+		if err := jc.RPC("GET", a.Content, nil, &b); err != nil {
+			return fmt.Errorf("could not mirror attachment %s: %v", a.Filename, err)
+		}
+		if err := os.MkdirAll(adir, 0700); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(file, b, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SyncMirror refreshes the mirror. Each target is synced incrementally,
+// fetching only the issues updated since its last sync, with a minute of
+// margin as JQL dates have minute precision. The keys matching each target
+// are refetched in full, replacing its listing, so that issues that stopped
+// matching drop out of it. If a git repository is configured, the synced
+// issues are git exported as well.
+func SyncMirror(jc *Client) error {
+	if jc.Offline() {
+		return errors.New("cannot sync in offline mode")
+	}
+
+	jc.mirror.syncLock.Lock()
+	defer jc.mirror.syncLock.Unlock()
+
+	jc.mirror.Lock()
+	attachments := jc.mirror.state.Attachments
+	jc.mirror.Unlock()
+
+	var failed, synced []string
+	for _, t := range jc.mirror.Targets() {
+		start := time.Now()
+		listing, err := getAllKeysForSearch(jc, t.Query)
+		keys := listing
+		if err == nil && !t.LastSync.IsZero() {
+			var when string
+			when, err = jqlTime(jc, t.LastSync.Add(-time.Minute))
+			since := fmt.Sprintf("updated >= \"%s\"", when)
+			query := since
+			if q, _ := SplitOrderBy(t.Query); q != "" {
+				query = fmt.Sprintf("(%s) AND %s", q, since)
+			}
+			if err == nil {
+				keys, err = getAllKeysForSearch(jc, query)
+			}
+		}
+
+		result := "ok"
+		if err == nil {
+			var n int
+			for _, k := range keys {
+				if err = mirrorIssue(jc, k, attachments); err != nil {
+					break
+				}
+				synced = append(synced, k)
+				n++
+			}
+			result = fmt.Sprintf("%d issues synced", n)
+		}
+		if err != nil {
+			result = fmt.Sprintf("error: %v", err)
+			failed = append(failed, t.Name)
+		}
+
+		jc.mirror.Lock()
+		for _, mt := range jc.mirror.state.Targets {
+			if mt.Name != t.Name {
+				continue
+			}
+			mt.Result = result
+			if err != nil {
+				continue
+			}
+			mt.LastSync = start
+			if jc.mirror.state.Listings == nil {
+				jc.mirror.state.Listings = make(map[string][]string)
+			}
+			jc.mirror.state.Listings[t.Query] = listing
+		}
+		if err := jc.mirror.save(); err != nil {
+			log.Printf("Could not save mirror state: %v", err)
+		}
+		jc.mirror.Unlock()
+	}
+
+	if jc.gitDir != "" && len(synced) > 0 {
+		if _, err := GitExport(jc, uniqueStrings(synced)); err != nil {
+			failed = append(failed, fmt.Sprintf("git export (%v)", err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("could not sync %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func uniqueStrings(ss []string) []string {
+	var res []string
+	seen := make(map[string]bool)
+	for _, s := range ss {
+		if !seen[s] {
+			seen[s] = true
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// RunSyncs periodically syncs the mirror, unless in offline mode.
+func RunSyncs(jc *Client, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	for range time.Tick(interval) {
+		if jc.Offline() || len(jc.mirror.Targets()) == 0 {
+			continue
+		}
+		if err := SyncMirror(jc); err != nil {
+			log.Printf("Could not sync mirror: %v", err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSortKeys(t *testing.T) {
+	tests := []struct {
+		in, want []string
+	}{
+		{nil, nil},
+		{[]string{"ABC-1"}, []string{"ABC-1"}},
+		{[]string{"ABC-2", "ABC-10", "ABC-9"}, []string{"ABC-10", "ABC-9", "ABC-2"}},
+		{[]string{"DEF-1", "ABC-1", "ABC-2"}, []string{"ABC-2", "ABC-1", "DEF-1"}},
+		{[]string{"A-B-1", "A-B-3", "A-2"}, []string{"A-2", "A-B-3", "A-B-1"}},
+	}
+
+	for _, tt := range tests {
+		got := append([]string(nil), tt.in...)
+		sortKeys(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sortKeys(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMirrorKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jirafs-mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// ABC-5 no longer matches any target.
+	for _, k := range []string{"ABC-1", "ABC-12", "ABC-3", "ABC-5", "DEF-7"} {
+		if err := os.MkdirAll(filepath.Join(dir, "issues", k), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var m Mirror
+	if err := m.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddTarget("abc", "ABC"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddTarget("mine", "assignee = currentUser()"); err != nil {
+		t.Fatal(err)
+	}
+	m.state.Listings = map[string][]string{
+		"project = ABC":            {"ABC-12", "ABC-3", "ABC-1"},
+		"assignee = currentUser()": {"DEF-7", "ABC-3"},
+	}
+
+	tests := []struct {
+		query string
+		max   int
+		want  []string
+		total int
+		err   bool
+	}{
+		{"", -1, []string{"ABC-12", "ABC-3", "ABC-1", "DEF-7"}, 4, false},
+		{"", 2, []string{"ABC-12", "ABC-3"}, 4, false},
+		{"project = ABC", -1, []string{"ABC-12", "ABC-3", "ABC-1"}, 3, false},
+		{`project = "abc"`, -1, []string{"ABC-12", "ABC-3", "ABC-1"}, 3, false},
+		{`project = "def"`, -1, []string{"DEF-7"}, 1, false},
+		{"project = XYZ", -1, nil, 0, false},
+		{"assignee = currentUser()", -1, []string{"DEF-7", "ABC-3"}, 2, false},
+		{"assignee = currentUser()", 1, []string{"DEF-7"}, 2, false},
+		{"status = Open", -1, nil, 0, true},
+	}
+
+	for _, tt := range tests {
+		keys, total, err := m.Keys(tt.query, tt.max)
+		if (err != nil) != tt.err {
+			t.Errorf("Keys(%q, %d): err = %v, want error %v", tt.query, tt.max, err, tt.err)
+			continue
+		}
+		if !reflect.DeepEqual(keys, tt.want) || total != tt.total {
+			t.Errorf("Keys(%q, %d) = %q, %d, want %q, %d", tt.query, tt.max, keys, total, tt.want, tt.total)
+		}
+	}
+
+	// Removing the target drops its listing.
+	if err := m.RemoveTarget("mine"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := m.Keys("assignee = currentUser()", -1); err == nil {
+		t.Errorf("Keys of a removed target: expected error")
+	}
+	if keys, _, err := m.Keys("", -1); err != nil || !reflect.DeepEqual(keys, []string{"ABC-12", "ABC-3", "ABC-1"}) {
+		t.Errorf("Keys after removing a target = %q, %v", keys, err)
+	}
+}
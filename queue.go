@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// replayInterval is how often queued writes are retried.
+const replayInterval = 30 * time.Second
+
+// QueuedWrite is a write that failed because JIRA could not be reached.
+type QueuedWrite struct {
+	ID     int       `json:"id"`
+	Queued time.Time `json:"queued"`
+	User   string    `json:"user,omitempty"`
+	Kind   string    `json:"kind"`
+	Issue  string    `json:"issue"`
+	Field  string    `json:"field,omitempty"`
+	Value  string    `json:"value"`
+
+	// Values holds the transition screen values of transitions.
+	Values map[string]string `json:"values,omitempty"`
+}
+
+func (qw *QueuedWrite) apply(jc *Client) error {
+	switch qw.Kind {
+	case "field":
+		return setFieldInIssue(jc, qw.Issue, qw.Field, qw.Value)
+	case "comment":
+		return addComment(jc, qw.Issue, qw.Value)
+	case "transition":
+		return transitionIssueWithFields(jc, qw.Issue, qw.Value, qw.Values)
+	default:
+		return fmt.Errorf("unknown write kind %s", qw.Kind)
+	}
+}
+
+// WriteQueue holds writes to be replayed in order once JIRA can be reached.
+// If a file is set, the queue is persisted to it. The zero value is an
+// in-memory queue.
+type WriteQueue struct {
+	sync.Mutex
+	file   string
+	nextID int
+	writes []QueuedWrite
+
+	// replayLock serializes replays.
+	replayLock sync.Mutex
+}
+
+// Load reads the queued writes from the file, and persists the queue to it
+// from then on.
+func (wq *WriteQueue) Load(file string) error {
+	wq.Lock()
+	defer wq.Unlock()
+	wq.file = file
+
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, &wq.writes); err != nil {
+		return fmt.Errorf("could not parse write queue: %v", err)
+	}
+	for _, w := range wq.writes {
+		if w.ID > wq.nextID {
+			wq.nextID = w.ID
+		}
+	}
+	return nil
+}
+
+// save persists the queue. The lock must be held.
+func (wq *WriteQueue) save() error {
+	if wq.file == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(wq.writes, "", "	")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(wq.file), 0700); err != nil {
+		return err
+	}
+
+	tmp := wq.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, wq.file)
+}
+
+// Add queues the write, returning its id.
+func (wq *WriteQueue) Add(w QueuedWrite) (int, error) {
+	wq.Lock()
+	defer wq.Unlock()
+
+	wq.nextID++
+	w.ID = wq.nextID
+	w.Queued = time.Now()
+	wq.writes = append(wq.writes, w)
+	return w.ID, wq.save()
+}
+
+func (wq *WriteQueue) remove(id int) error {
+	wq.Lock()
+	defer wq.Unlock()
+
+	for i := range wq.writes {
+		if wq.writes[i].ID == id {
+			wq.writes = append(wq.writes[:i], wq.writes[i+1:]...)
+			break
+		}
+	}
+	return wq.save()
+}
+
+// Pending returns whether writes to the issue are queued.
+func (wq *WriteQueue) Pending(issue string) bool {
+	wq.Lock()
+	defer wq.Unlock()
+	for _, w := range wq.writes {
+		if w.Issue == issue {
+			return true
+		}
+	}
+	return false
+}
+
+func (wq *WriteQueue) Writes() []QueuedWrite {
+	wq.Lock()
+	defer wq.Unlock()
+	return append([]QueuedWrite(nil), wq.writes...)
+}
+
+// String renders the queue as JSON lines.
+func (wq *WriteQueue) String() string {
+	var s string
+	for _, w := range wq.Writes() {
+		b, err := json.Marshal(w)
+		if err != nil {
+			continue
+		}
+		s += string(b) + "\n"
+	}
+	return s
+}
+
+// QueuedError is returned by writes that were queued rather than applied.
+// The write is not lost, so callers generally treat it as success.
+type QueuedError struct {
+	ID int
+}
+
+func (qe *QueuedError) Error() string {
+	return fmt.Sprintf("JIRA could not be reached, queued as write %d", qe.ID)
+}
+
+// IsQueued returns whether err is a QueuedError.
+func IsQueued(err error) bool {
+	_, ok := err.(*QueuedError)
+	return ok
+}
+
+// queueOnFailure runs the write, queueing it instead if it failed because
+// JIRA could not be reached, in which case a QueuedError is returned. Writes
+// to issues with queued writes are queued as well, to keep them in order,
+// and all writes are queued in offline mode. Queued writes are recorded in
+// the journal with a note, and journaled as mutations once replayed.
+func queueOnFailure(jc *Client, w QueuedWrite, write func() error) error {
+	if !jc.Offline() && !jc.queue.Pending(w.Issue) {
+		err := write()
+		if !IsNetworkError(err) {
+			return err
+		}
+		log.Printf("Could not reach JIRA, queueing %s write to %s: %v", w.Kind, w.Issue, err)
+	}
+
+	w.User = jc.User()
+	id, err := jc.queue.Add(w)
+	if err != nil {
+		return fmt.Errorf("could not queue write: %v", err)
+	}
+
+	journalMutation(jc, JournalEntry{
+		Op:    w.Kind,
+		Issue: w.Issue,
+		Field: w.Field,
+		New:   w.Value,
+		Note:  fmt.Sprintf("queued as write %d", id),
+	})
+	return &QueuedError{ID: id}
+}
+
+// SetFieldInIssue sets the field of the issue, queueing the write if JIRA
+// cannot be reached.
+func SetFieldInIssue(jc *Client, issue, field, val string) error {
+	w := QueuedWrite{Kind: "field", Issue: issue, Field: field, Value: val}
+	return queueOnFailure(jc, w, func() error { return w.apply(jc) })
+}
+
+// AddComment adds a comment to the issue, queueing the write if JIRA cannot
+// be reached.
+func AddComment(jc *Client, issue, body string) error {
+	w := QueuedWrite{Kind: "comment", Issue: issue, Value: body}
+	return queueOnFailure(jc, w, func() error { return w.apply(jc) })
+}
+
+// TransitionIssueWithFields executes the named transition, filling the
+// transition screen with the provided values, queueing the write if JIRA
+// cannot be reached.
+func TransitionIssueWithFields(jc *Client, issue, transition string, values map[string]string) error {
+	w := QueuedWrite{Kind: "transition", Issue: issue, Value: transition, Values: values}
+	return queueOnFailure(jc, w, func() error { return w.apply(jc) })
+}
+
+const jiraTimeLayout = "2006-01-02T15:04:05.000-0700"
+
+func getIssueUpdated(jc *Client, issue string) (time.Time, error) {
+	var res struct {
+		Fields struct {
+			Updated string `json:"updated"`
+		} `json:"fields"`
+	}
+	url := fmt.Sprintf("/rest/api/2/issue/%s?fields=updated", issue)
+	if err := jc.RPC("GET", url, nil, &res); err != nil {
+		return time.Time{}, wrapError("could not get issue", err)
+	}
+	return time.Parse(jiraTimeLayout, res.Fields.Updated)
+}
+
+// ReplayWrites applies the queued writes in order, on behalf of the users
+// that made them, stopping if JIRA still cannot be reached. Field writes and
+// transitions to issues that were updated on the server after the write was
+// queued are conflicts, and are dropped rather than applied. Replayed writes
+// are journaled as mutations, and conflicts and failures are reported in the
+// journal.
+func ReplayWrites(jc *Client) error {
+	if jc.Offline() {
+		return errors.New("cannot replay in offline mode")
+	}
+
+	jc.queue.replayLock.Lock()
+	defer jc.queue.replayLock.Unlock()
+
+	// Once a write to an issue has been replayed, its updated time is ours.
+	replayed := make(map[string]bool)
+
+	for _, w := range jc.queue.Writes() {
+		wjc := jc.WithScope(w.User, "/pending")
+
+		var note string
+		if w.Kind != "comment" && !replayed[w.Issue] {
+			updated, err := getIssueUpdated(wjc, w.Issue)
+			if IsNetworkError(err) {
+				return err
+			}
+			if err == nil && updated.After(w.Queued) {
+				note = fmt.Sprintf("conflict: issue updated at %v, after the write was queued, not applied", updated)
+			}
+		}
+
+		if note == "" {
+			err := w.apply(wjc)
+			if IsNetworkError(err) {
+				return err
+			}
+			if err != nil {
+				note = fmt.Sprintf("failed: %v", err)
+			} else {
+				replayed[w.Issue] = true
+			}
+		}
+
+		if note != "" {
+			journalMutation(wjc, JournalEntry{
+				Op:    w.Kind,
+				Issue: w.Issue,
+				Field: w.Field,
+				New:   w.Value,
+				Note:  fmt.Sprintf("%s: write %d queued at %v", note, w.ID, w.Queued),
+			})
+		}
+
+		if err := jc.queue.remove(w.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunReplays periodically replays queued writes.
+func RunReplays(jc *Client) {
+	for range time.Tick(replayInterval) {
+		if jc.Offline() || len(jc.queue.Writes()) == 0 {
+			continue
+		}
+		if err := ReplayWrites(jc); err != nil {
+			log.Printf("Could not replay queued writes: %v", err)
+		}
+	}
+}
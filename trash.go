@@ -0,0 +1,280 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+)
+
+const defaultDeleteWindow = time.Minute
+
+type pendingDelete struct {
+	token   string
+	expires time.Time
+}
+
+// PendingDeletes holds the armed deletions and moves awaiting confirmation,
+// by issue key. Moves are keyed by "move:KEY:PROJECT". The zero value has no
+// pending deletions.
+type PendingDeletes struct {
+	sync.Mutex
+	pending map[string]pendingDelete
+}
+
+// Arm arms the deletion of the issue for the duration of window, returning
+// the token needed to confirm it.
+func (pd *PendingDeletes) Arm(key string, window time.Duration) (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	pd.Lock()
+	defer pd.Unlock()
+	if pd.pending == nil {
+		pd.pending = make(map[string]pendingDelete)
+	}
+	pd.pending[key] = pendingDelete{token: token, expires: time.Now().Add(window)}
+	return token, nil
+}
+
+// Confirm checks and consumes the token for the armed deletion or move.
+func (pd *PendingDeletes) Confirm(key, token string) error {
+	pd.Lock()
+	defer pd.Unlock()
+
+	p, exists := pd.pending[key]
+	if !exists {
+		return errors.New("not armed")
+	}
+	if time.Now().After(p.expires) {
+		delete(pd.pending, key)
+		return errors.New("expired")
+	}
+	if p.token != token {
+		return errors.New("invalid token")
+	}
+	delete(pd.pending, key)
+	return nil
+}
+
+// TrashedIssue is the archive of a deleted issue.
+type TrashedIssue struct {
+	Key      string          `json:"key"`
+	Deleted  time.Time       `json:"deleted"`
+	Issue    json.RawMessage `json:"issue"`
+	Comments json.RawMessage `json:"comments"`
+	Worklogs json.RawMessage `json:"worklogs"`
+
+	// Restored is the key of the issue recreated from the archive, if any.
+	Restored string `json:"restored,omitempty"`
+}
+
+func trashPath(jc *Client, key string) string {
+	return filepath.Join(jc.trashDir, key+".json")
+}
+
+// TrashIssue archives the issue, its comments and worklogs to the trash
+// directory.
+func TrashIssue(jc *Client, key string) error {
+	ti := TrashedIssue{Key: key, Deleted: time.Now()}
+
+	u := fmt.Sprintf("/rest/api/2/issue/%s", key)
+	if err := jc.RPC("GET", u, nil, &ti.Issue); err != nil {
+		return fmt.Errorf("could not archive issue: %v", err)
+	}
+	u = fmt.Sprintf("/rest/api/2/issue/%s/comment?maxResults=1000", key)
+	if err := jc.RPC("GET", u, nil, &ti.Comments); err != nil {
+		return fmt.Errorf("could not archive comments: %v", err)
+	}
+	u = fmt.Sprintf("/rest/api/2/issue/%s/worklog", key)
+	if err := jc.RPC("GET", u, nil, &ti.Worklogs); err != nil {
+		return fmt.Errorf("could not archive worklogs: %v", err)
+	}
+
+	return saveTrashedIssue(jc, &ti)
+}
+
+func saveTrashedIssue(jc *Client, ti *TrashedIssue) error {
+	b, err := json.MarshalIndent(ti, "", "	")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(jc.trashDir, 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(trashPath(jc, ti.Key), b, 0600)
+}
+
+// SafeDeleteIssue archives the issue to the trash directory, and deletes it
+// only if archiving succeeded.
+func SafeDeleteIssue(jc *Client, key string) error {
+	if err := TrashIssue(jc, key); err != nil {
+		return err
+	}
+	return DeleteIssue(jc, key)
+}
+
+// GetTrashedIssues returns the keys of the archived issues.
+func GetTrashedIssues(jc *Client) ([]string, error) {
+	files, err := ioutil.ReadDir(jc.trashDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".json") {
+			keys = append(keys, strings.TrimSuffix(f.Name(), ".json"))
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// GetTrashedIssue reads the archive of the issue. The key must be an issue
+// key, so that it cannot name a file outside of the trash directory.
+func GetTrashedIssue(jc *Client, key string) (*TrashedIssue, error) {
+	if !endpointKey.MatchString(key) {
+		return nil, fmt.Errorf("invalid issue key: %s", key)
+	}
+
+	b, err := ioutil.ReadFile(trashPath(jc, key))
+	if err != nil {
+		return nil, err
+	}
+
+	var ti TrashedIssue
+	if err := json.Unmarshal(b, &ti); err != nil {
+		return nil, err
+	}
+	return &ti, nil
+}
+
+type trashedWorklog struct {
+	Worklogs []struct {
+		Comment          string `json:"comment"`
+		Started          string `json:"started"`
+		TimeSpentSeconds int    `json:"timeSpentSeconds"`
+	} `json:"worklogs"`
+}
+
+// RestoreIssue recreates an archived issue as best it can: the summary,
+// description, type, priority, labels, components and links are restored,
+// and comments and worklogs are re-added with their original author and time
+// noted. The archive is kept, marked with the new key, and cannot be restored
+// again. It returns the new key and notes about what could not be restored.
+func RestoreIssue(jc *Client, key string) (string, []string, error) {
+	jc.restoreLock.Lock()
+	defer jc.restoreLock.Unlock()
+
+	ti, err := GetTrashedIssue(jc, key)
+	if err != nil {
+		return "", nil, err
+	}
+	if ti.Restored != "" {
+		return "", nil, fmt.Errorf("already restored as %s", ti.Restored)
+	}
+
+	var issue jira.Issue
+	if err := json.Unmarshal(ti.Issue, &issue); err != nil {
+		return "", nil, err
+	}
+	if issue.Fields == nil {
+		return "", nil, errors.New("archive missing fields")
+	}
+
+	fields := map[string]interface{}{
+		"project":     map[string]string{"key": issue.Fields.Project.Key},
+		"issuetype":   map[string]string{"name": issue.Fields.Type.Name},
+		"summary":     issue.Fields.Summary,
+		"description": issue.Fields.Description,
+		"labels":      issue.Fields.Labels,
+	}
+	if issue.Fields.Priority != nil {
+		fields["priority"] = map[string]string{"name": issue.Fields.Priority.Name}
+	}
+	var components []map[string]string
+	for _, c := range issue.Fields.Components {
+		components = append(components, map[string]string{"name": c.Name})
+	}
+	if len(components) > 0 {
+		fields["components"] = components
+	}
+
+	newKey, err := CreateIssueFromFields(jc, fields)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var notes []string
+	ti.Restored = newKey
+	if err := saveTrashedIssue(jc, ti); err != nil {
+		notes = append(notes, fmt.Sprintf("archive not marked as restored: %v", err))
+	}
+
+	for _, l := range issue.Fields.IssueLinks {
+		var err error
+		switch {
+		case l.OutwardIssue != nil:
+			err = LinkIssues(jc, newKey, l.OutwardIssue.Key, l.Type.Name)
+		case l.InwardIssue != nil:
+			err = LinkIssues(jc, l.InwardIssue.Key, newKey, l.Type.Name)
+		}
+		if err != nil {
+			notes = append(notes, fmt.Sprintf("link %s not restored: %v", renderIssueLink(l, key), err))
+		}
+	}
+
+	var cr CommentResult
+	if err := json.Unmarshal(ti.Comments, &cr); err != nil {
+		notes = append(notes, fmt.Sprintf("comments not restored: %v", err))
+	}
+	for _, c := range cr.Comments {
+		body := fmt.Sprintf("[Comment by %s on %s, restored from %s]\n%s", c.Author.Name, c.Created, key, c.Body)
+		if err := AddComment(jc, newKey, body); err != nil {
+			notes = append(notes, fmt.Sprintf("comment %s not restored: %v", c.ID, err))
+		}
+	}
+
+	var tw trashedWorklog
+	if err := json.Unmarshal(ti.Worklogs, &tw); err != nil {
+		notes = append(notes, fmt.Sprintf("worklogs not restored: %v", err))
+	}
+	for _, w := range tw.Worklogs {
+		if err := AddWorklog(jc, newKey, w.Started, w.TimeSpentSeconds, w.Comment); err != nil {
+			notes = append(notes, fmt.Sprintf("worklog from %s not restored: %v", w.Started, err))
+		}
+	}
+
+	if err := ChangeStatus(jc, newKey, issueStatusName(&issue), nil, nil); err != nil {
+		notes = append(notes, fmt.Sprintf("status not restored: %v", err))
+	}
+
+	log.Printf("Restored %s as %s", key, newKey)
+	return newKey, notes, nil
+}
+
+func issueStatusName(issue *jira.Issue) string {
+	if issue.Fields == nil || issue.Fields.Status == nil {
+		return ""
+	}
+	return issue.Fields.Status.Name
+}
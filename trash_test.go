@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetTrashedIssue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jirafs-trash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	trashDir := filepath.Join(dir, "trash")
+	if err := os.MkdirAll(trashDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	archive := []byte(`{"key": "ABC-1", "issue": {}}`)
+	for _, file := range []string{filepath.Join(trashDir, "ABC-1.json"), filepath.Join(dir, "secret.json")} {
+		if err := ioutil.WriteFile(file, archive, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	jc := &Client{clientState: &clientState{trashDir: trashDir}}
+	tests := []struct {
+		key string
+		err bool
+	}{
+		{"ABC-1", false},
+		{"ABC-2", true},
+		{"../secret", true},
+		{"../ABC-1", true},
+		{"abc-1", true},
+		{"", true},
+	}
+
+	for _, tt := range tests {
+		ti, err := GetTrashedIssue(jc, tt.key)
+		if (err != nil) != tt.err {
+			t.Errorf("GetTrashedIssue(%q): err = %v, want error %v", tt.key, err, tt.err)
+			continue
+		}
+		if err == nil && ti.Key != tt.key {
+			t.Errorf("GetTrashedIssue(%q) = %s", tt.key, ti.Key)
+		}
+	}
+}
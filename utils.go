@@ -1,16 +1,26 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/andygrunwald/go-jira"
 	"github.com/joushou/qp"
 )
 
 type SearchResult struct {
-	Issues []jira.Issue `json:"issues"`
+	StartAt    int          `json:"startAt"`
+	MaxResults int          `json:"maxResults"`
+	Total      int          `json:"total"`
+	Issues     []jira.Issue `json:"issues"`
 }
 
 func GetProject(jc *Client, projectKey string) (*jira.Project, error) {
@@ -23,8 +33,15 @@ func GetProject(jc *Client, projectKey string) (*jira.Project, error) {
 }
 
 func GetProjects(jc *Client) ([]jira.Project, error) {
+	if jc.Offline() {
+		return jc.mirror.Projects()
+	}
+
 	var projects []jira.Project
 	if err := jc.RPC("GET", "/rest/api/2/project", nil, &projects); err != nil {
+		if IsNetworkError(err) {
+			return jc.mirror.Projects()
+		}
 		return nil, fmt.Errorf("could not query projects: %v", err)
 	}
 	return projects, nil
@@ -43,11 +60,39 @@ func GetTypesForProject(jc *Client, projectKey string) ([]string, error) {
 	return ss, nil
 }
 
+func GetTypeIDForProject(jc *Client, projectKey, typeName string) (string, error) {
+	p, err := GetProject(jc, projectKey)
+	if err != nil {
+		return "", err
+	}
+
+	for _, tp := range p.IssueTypes {
+		if tp.Name == typeName {
+			return tp.ID, nil
+		}
+	}
+	return "", fmt.Errorf("no such issue type: %s", typeName)
+}
+
 func GetKeysForSearch(jc *Client, query string, max int) ([]string, error) {
+	keys, _, err := GetKeysAndTotalForSearch(jc, query, max)
+	return keys, err
+}
+
+// GetKeysAndTotalForSearch returns the keys of at most max issues matching the
+// query, as well as the total number of matching issues.
+func GetKeysAndTotalForSearch(jc *Client, query string, max int) ([]string, int, error) {
+	if jc.Offline() {
+		return jc.mirror.Keys(query, max)
+	}
+
 	var s SearchResult
 	url := fmt.Sprintf("/rest/api/2/search?fields=key&maxResults=%d&jql=%s", max, url.QueryEscape(query))
 	if err := jc.RPC("GET", url, nil, &s); err != nil {
-		return nil, fmt.Errorf("could not execute search: %v", err)
+		if IsNetworkError(err) {
+			return jc.mirror.Keys(query, max)
+		}
+		return nil, 0, fmt.Errorf("could not execute search: %v", err)
 	}
 
 	ss := make([]string, len(s.Issues))
@@ -55,19 +100,169 @@ func GetKeysForSearch(jc *Client, query string, max int) ([]string, error) {
 		ss[i] = issue.Key
 	}
 
-	return ss, nil
+	return ss, s.Total, nil
+}
+
+// GetAllKeysForSearch returns the keys of all issues matching the query,
+// paginating through the results. In offline mode, or if JIRA cannot be
+// reached, the keys are taken from the mirror.
+func GetAllKeysForSearch(jc *Client, query string) ([]string, error) {
+	if jc.Offline() {
+		keys, _, err := jc.mirror.Keys(query, -1)
+		return keys, err
+	}
+
+	keys, err := getAllKeysForSearch(jc, query)
+	if IsNetworkError(err) {
+		keys, _, err = jc.mirror.Keys(query, -1)
+	}
+	return keys, err
+}
+
+func getAllKeysForSearch(jc *Client, query string) ([]string, error) {
+	var ss []string
+	for {
+		var s SearchResult
+		url := fmt.Sprintf("/rest/api/2/search?fields=key&startAt=%d&maxResults=100&jql=%s", len(ss), url.QueryEscape(query))
+		if err := jc.RPC("GET", url, nil, &s); err != nil {
+			return nil, wrapError("could not execute search", err)
+		}
+
+		for _, issue := range s.Issues {
+			ss = append(ss, issue.Key)
+		}
+
+		if len(s.Issues) == 0 || len(ss) >= s.Total {
+			return ss, nil
+		}
+	}
+}
+
+// DefaultIndexColumns are the columns of index files unless configured.
+var DefaultIndexColumns = []string{"key", "status", "priority", "assignee", "updated", "summary"}
+
+// indexFieldAliases maps index column names to field ids.
+var indexFieldAliases = map[string]string{
+	"type":            "issuetype",
+	"fixversions":     "fixVersions",
+	"affectsversions": "versions",
+}
+
+// ColumnFields returns the field ids for index columns.
+func ColumnFields(columns []string) []string {
+	var fields []string
+	for _, c := range columns {
+		if f, exists := indexFieldAliases[c]; exists {
+			c = f
+		}
+		fields = append(fields, c)
+	}
+	return fields
+}
+
+// RenderFieldValue renders a raw JSON field value on a single line. Objects
+// are rendered by their name, value or key, and arrays as comma separated
+// lists.
+func RenderFieldValue(raw json.RawMessage) string {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return ""
+	}
+
+	var render func(v interface{}) string
+	render = func(v interface{}) string {
+		switch x := v.(type) {
+		case nil:
+			return ""
+		case string:
+			return x
+		case float64:
+			return strconv.FormatFloat(x, 'f', -1, 64)
+		case bool:
+			return strconv.FormatBool(x)
+		case []interface{}:
+			var ss []string
+			for _, e := range x {
+				ss = append(ss, render(e))
+			}
+			return strings.Join(ss, ",")
+		case map[string]interface{}:
+			for _, k := range []string{"name", "value", "key", "displayName"} {
+				if s, ok := x[k].(string); ok {
+					return s
+				}
+			}
+			b, _ := json.Marshal(x)
+			return string(b)
+		default:
+			return ""
+		}
+	}
+
+	return strings.Join(strings.Fields(render(v)), " ")
+}
+
+type RawIssue struct {
+	Key    string                     `json:"key"`
+	Fields map[string]json.RawMessage `json:"fields"`
+}
+
+type RawSearchResult struct {
+	StartAt    int        `json:"startAt"`
+	MaxResults int        `json:"maxResults"`
+	Total      int        `json:"total"`
+	Issues     []RawIssue `json:"issues"`
+}
+
+// GetIndexForSearch renders a tab-separated table of at most max issues
+// matching the query, with a header row naming the columns. The table is
+// fetched in a single search request.
+func GetIndexForSearch(jc *Client, query string, columns []string, max int) (string, error) {
+	fields := ColumnFields(columns)
+
+	s := &RawSearchResult{}
+	if jc.Offline() {
+		var err error
+		if s, err = jc.mirror.Search(query, max); err != nil {
+			return "", err
+		}
+	} else {
+		url := fmt.Sprintf("/rest/api/2/search?fields=%s&maxResults=%d&jql=%s", url.QueryEscape(strings.Join(fields, ",")), max, url.QueryEscape(query))
+		if err := jc.RPC("GET", url, nil, s); err != nil {
+			if !IsNetworkError(err) {
+				return "", fmt.Errorf("could not execute search: %v", err)
+			}
+			if s, err = jc.mirror.Search(query, max); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	res := strings.Join(columns, "\t") + "\n"
+	for _, issue := range s.Issues {
+		row := make([]string, len(fields))
+		for i, f := range fields {
+			if f == "key" {
+				row[i] = issue.Key
+				continue
+			}
+			row[i] = RenderFieldValue(issue.Fields[f])
+		}
+		res += strings.Join(row, "\t") + "\n"
+	}
+
+	return res, nil
 }
 
 func GetKeysForNIssuesInProject(jc *Client, project string, max int) ([]string, error) {
-	var s SearchResult
-	url := fmt.Sprintf("/rest/api/2/search?fields=key&maxResults=%d&jql=project=%s", max, project)
-	if err := jc.RPC("GET", url, nil, &s); err != nil {
-		return nil, fmt.Errorf("could not execute search: %v", err)
+	keys, err := GetKeysForSearch(jc, fmt.Sprintf("project = %s", project), max)
+	if err != nil {
+		return nil, err
 	}
 
-	ss := make([]string, len(s.Issues))
-	for i, issue := range s.Issues {
-		s := strings.Split(issue.Key, "-")
+	ss := make([]string, len(keys))
+	for i, key := range keys {
+		s := strings.Split(key, "-")
 		if len(s) != 2 {
 			continue
 		}
@@ -78,9 +273,18 @@ func GetKeysForNIssuesInProject(jc *Client, project string, max int) ([]string,
 }
 
 func GetIssue(jc *Client, key string) (*jira.Issue, error) {
+	if jc.Offline() {
+		return jc.mirror.Issue(key)
+	}
+
 	var i jira.Issue
 	u := fmt.Sprintf("/rest/api/2/issue/%s", key)
 	if err := jc.RPC("GET", u, nil, &i); err != nil {
+		if IsNetworkError(err) {
+			if mi, merr := jc.mirror.Issue(key); merr == nil {
+				return mi, nil
+			}
+		}
 		return nil, fmt.Errorf("could not query issue: %v", err)
 	}
 	return &i, nil
@@ -96,22 +300,63 @@ func CreateIssue(jc *Client, issue *jira.Issue) (string, error) {
 	if err := jc.RPC("POST", "/rest/api/2/issue", issue, &cir); err != nil {
 		return "", fmt.Errorf("could not create issue: %v", err)
 	}
+
+	var summary string
+	if issue.Fields != nil {
+		summary = issue.Fields.Summary
+	}
+	journalMutation(jc, JournalEntry{Op: "create", Issue: cir.Key, New: summary})
+	return cir.Key, nil
+}
+
+// CreateIssueFromFields creates an issue from raw fields, returning the key.
+func CreateIssueFromFields(jc *Client, fields map[string]interface{}) (string, error) {
+	var cir CreateIssueResult
+	post := map[string]interface{}{"fields": fields}
+	if err := jc.RPC("POST", "/rest/api/2/issue", post, &cir); err != nil {
+		return "", fmt.Errorf("could not create issue: %v", err)
+	}
+
+	summary, _ := fields["summary"].(string)
+	journalMutation(jc, JournalEntry{Op: "create", Issue: cir.Key, New: summary})
 	return cir.Key, nil
 }
 
+// DeleteIssue deletes the issue. Use SafeDeleteIssue to archive it to the
+// trash first, so that the deletion can be undone.
 func DeleteIssue(jc *Client, issue string) error {
 	url := fmt.Sprintf("/rest/api/2/issue/%s", issue)
 	if err := jc.RPC("DELETE", url, nil, nil); err != nil {
 		return fmt.Errorf("could not delete issue: %v", err)
 	}
+
+	journalMutation(jc, JournalEntry{Op: "delete", Issue: issue})
 	return nil
 }
 
 func DeleteIssueLink(jc *Client, issueLinkID string) error {
 	url := fmt.Sprintf("/rest/api/2/issueLink/%s", issueLinkID)
+
+	var link jira.IssueLink
+	if err := jc.RPC("GET", url, nil, &link); err != nil {
+		log.Printf("Could not get issue link %s for the journal: %v", issueLinkID, err)
+	}
+
 	if err := jc.RPC("DELETE", url, nil, nil); err != nil {
 		return fmt.Errorf("could not delete issue link: %v", err)
 	}
+
+	e := JournalEntry{Op: "unlink", Issue: "link/" + issueLinkID, Field: "links"}
+	if link.InwardIssue != nil && link.OutwardIssue != nil {
+		e.Issue = link.InwardIssue.Key
+		e.Old = fmt.Sprintf("%s %s %s", link.InwardIssue.Key, link.OutwardIssue.Key, link.Type.Name)
+		e.Revert = newRevert("POST", "/rest/api/2/issueLink", map[string]interface{}{
+			"type":         map[string]string{"name": link.Type.Name},
+			"inwardIssue":  map[string]string{"key": link.InwardIssue.Key},
+			"outwardIssue": map[string]string{"key": link.OutwardIssue.Key},
+		})
+	}
+	journalMutation(jc, e)
 	return nil
 }
 
@@ -131,31 +376,97 @@ func LinkIssues(jc *Client, inwardKey, outwardKey, relation string) error {
 	if err := jc.RPC("POST", "/rest/api/2/issueLink", issueLink, nil); err != nil {
 		return fmt.Errorf("could not create issue link: %v", err)
 	}
+
+	journalMutation(jc, JournalEntry{
+		Op:    "link",
+		Issue: inwardKey,
+		Field: "links",
+		New:   fmt.Sprintf("%s %s %s", inwardKey, outwardKey, relation),
+	})
 	return nil
 }
 
 func GetWorklogForIssue(jc *Client, issue string) (*jira.Worklog, error) {
+	if jc.Offline() {
+		return jc.mirror.Worklog(issue)
+	}
+
 	var w jira.Worklog
 	url := fmt.Sprintf("/rest/api/2/issue/%s/worklog", issue)
 	if err := jc.RPC("GET", url, nil, &w); err != nil {
+		if IsNetworkError(err) {
+			if mw, merr := jc.mirror.Worklog(issue); merr == nil {
+				return mw, nil
+			}
+		}
 		return nil, fmt.Errorf("could not get worklog: %v", err)
 	}
 	return &w, nil
 }
 
 func GetSpecificWorklogForIssue(jc *Client, issue, worklog string) (*jira.WorklogRecord, error) {
+	if jc.Offline() {
+		return jc.mirror.WorklogRecord(issue, worklog)
+	}
+
 	var w jira.WorklogRecord
 	url := fmt.Sprintf("/rest/api/2/issue/%s/worklog/%s", issue, worklog)
 	if err := jc.RPC("GET", url, nil, &w); err != nil {
+		if IsNetworkError(err) {
+			if mw, merr := jc.mirror.WorklogRecord(issue, worklog); merr == nil {
+				return mw, nil
+			}
+		}
 		return nil, fmt.Errorf("could not get worklog: %v", err)
 	}
 	return &w, nil
 }
 
+// FieldMeta describes a field on a transition or create screen.
+type FieldMeta struct {
+	Name            string `json:"name"`
+	Required        bool   `json:"required"`
+	HasDefaultValue bool   `json:"hasDefaultValue"`
+	Schema          struct {
+		Type   string `json:"type"`
+		Items  string `json:"items"`
+		System string `json:"system"`
+		Custom string `json:"custom"`
+	} `json:"schema"`
+	AllowedValues []struct {
+		ID    string `json:"id"`
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"allowedValues"`
+}
+
+func AddWorklog(jc *Client, issue, started string, seconds int, comment string) error {
+	w := map[string]interface{}{
+		"started":          started,
+		"timeSpentSeconds": seconds,
+		"comment":          comment,
+	}
+	var created jira.WorklogRecord
+	url := fmt.Sprintf("/rest/api/2/issue/%s/worklog", issue)
+	if err := jc.RPC("POST", url, w, &created); err != nil {
+		return fmt.Errorf("could not add worklog: %v", err)
+	}
+
+	journalMutation(jc, JournalEntry{
+		Op:     "create",
+		Issue:  issue,
+		Field:  "worklog/" + created.ID,
+		New:    fmt.Sprintf("%ds from %s", seconds, started),
+		Revert: newRevert("DELETE", fmt.Sprintf("%s/%s", url, created.ID), nil),
+	})
+	return nil
+}
+
 type Transition struct {
-	ID     string            `json:"id,omitempty"`
-	Name   string            `json:"name,omitempty"`
-	Fields *jira.IssueFields `json:"fields,omitempty"`
+	ID     string               `json:"id,omitempty"`
+	Name   string               `json:"name,omitempty"`
+	To     *WorkflowStatus      `json:"to,omitempty"`
+	Fields map[string]FieldMeta `json:"fields,omitempty"`
 }
 
 type TransitionResult struct {
@@ -164,40 +475,196 @@ type TransitionResult struct {
 
 func GetTransitionsForIssue(jc *Client, issue string) ([]Transition, error) {
 	var tr TransitionResult
-	url := fmt.Sprintf("/rest/api/2/issue/%s/transitions", issue)
+	url := fmt.Sprintf("/rest/api/2/issue/%s/transitions?expand=transitions.fields", issue)
 	if err := jc.RPC("GET", url, nil, &tr); err != nil {
-		return nil, fmt.Errorf("could not get transitions: %v", err)
+		return nil, wrapError("could not get transitions", err)
 	}
 	return tr.Transitions, nil
 }
 
+// SplitQuoted splits a string on spaces, keeping double-quoted sections
+// together. The quotes are removed, and \" can be used for a literal quote.
+func SplitQuoted(s string) ([]string, error) {
+	var args []string
+	var cur []rune
+	inQuote, inToken, escaped := false, false, false
+	for _, r := range s {
+		switch {
+		case escaped:
+			cur = append(cur, r)
+			escaped = false
+		case r == '\\' && inQuote:
+			escaped = true
+		case r == '"':
+			inQuote = !inQuote
+			inToken = true
+		case (r == ' ' || r == '\t' || r == '\n') && !inQuote:
+			if inToken {
+				args = append(args, string(cur))
+				cur = nil
+				inToken = false
+			}
+		default:
+			cur = append(cur, r)
+			inToken = true
+		}
+	}
+	if inQuote {
+		return nil, errors.New("unterminated quote")
+	}
+	if inToken {
+		args = append(args, string(cur))
+	}
+	return args, nil
+}
+
+// ParseTransitionInput parses transition input in the form `Transition name
+// field=value comment="some text"`. The transition name is made up of the
+// words preceding the first field assignment.
+func ParseTransitionInput(s string) (string, map[string]string, error) {
+	args, err := SplitQuoted(s)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var name []string
+	values := make(map[string]string)
+	for _, arg := range args {
+		idx := strings.Index(arg, "=")
+		if idx <= 0 {
+			if len(values) > 0 {
+				return "", nil, fmt.Errorf("expected field=value, got %s", arg)
+			}
+			name = append(name, arg)
+			continue
+		}
+		values[strings.ToLower(arg[:idx])] = arg[idx+1:]
+	}
+
+	if len(name) == 0 {
+		return "", nil, errors.New("transition name missing")
+	}
+	return strings.Join(name, " "), values, nil
+}
+
+// fieldValue formats a value for a screen field according to its schema.
+func fieldValue(f FieldMeta, val string) (interface{}, error) {
+	named := func(v string) interface{} {
+		return map[string]string{"name": v}
+	}
+
+	switch f.Schema.Type {
+	case "string", "date", "datetime":
+		return val, nil
+	case "number":
+		return strconv.ParseFloat(val, 64)
+	case "option":
+		return map[string]string{"value": val}, nil
+	case "array":
+		var items []interface{}
+		for _, v := range strings.Split(val, ",") {
+			if v = strings.TrimSpace(v); v == "" {
+				continue
+			}
+			switch f.Schema.Items {
+			case "string":
+				items = append(items, v)
+			case "option":
+				items = append(items, map[string]string{"value": v})
+			default:
+				items = append(items, named(v))
+			}
+		}
+		return items, nil
+	default:
+		// user, resolution, priority, issuetype and friends.
+		return named(val), nil
+	}
+}
+
 func TransitionIssue(jc *Client, issue, transition string) error {
+	return TransitionIssueWithFields(jc, issue, transition, nil)
+}
+
+// transitionIssueWithFields executes the named transition, filling the
+// transition screen with the provided values, keyed by lower-cased field id
+// or name. The special "comment" key adds a comment along with the
+// transition.
+func transitionIssueWithFields(jc *Client, issue, transition string, values map[string]string) error {
 	transition = strings.Replace(transition, "\n", "", -1)
 	transitions, err := GetTransitionsForIssue(jc, issue)
 	if err != nil {
 		return err
 	}
-	var id string
-	for _, t := range transitions {
-		if transition == t.Name {
-			id = t.ID
+	var tr *Transition
+	for i := range transitions {
+		if transition == transitions[i].Name {
+			tr = &transitions[i]
 			break
 		}
 	}
 
-	if id == "" {
+	if tr == nil {
 		return fmt.Errorf("no such transition")
 	}
 
 	post := map[string]interface{}{
 		"transition": map[string]interface{}{
-			"id": id,
+			"id": tr.ID,
 		},
 	}
+
+	fields := make(map[string]interface{})
+	for key, val := range values {
+		if key == "comment" {
+			post["update"] = map[string]interface{}{
+				"comment": []interface{}{
+					map[string]interface{}{
+						"add": map[string]string{"body": val},
+					},
+				},
+			}
+			continue
+		}
+
+		var found bool
+		for id, f := range tr.Fields {
+			if key != strings.ToLower(id) && key != strings.ToLower(f.Name) {
+				continue
+			}
+			v, err := fieldValue(f, val)
+			if err != nil {
+				return fmt.Errorf("invalid value for %s: %v", key, err)
+			}
+			fields[id] = v
+			found = true
+			break
+		}
+		if !found {
+			return fmt.Errorf("field %s is not on the transition screen", key)
+		}
+	}
+	if len(fields) > 0 {
+		post["fields"] = fields
+	}
+
+	var from string
+	if raw, err := getRawField(jc, issue, "status"); err != nil {
+		log.Printf("Could not get status of %s for the journal: %v", issue, err)
+	} else {
+		from = journalValue(raw)
+	}
+
 	url := fmt.Sprintf("/rest/api/2/issue/%s/transitions", issue)
 	if err := jc.RPC("POST", url, post, nil); err != nil {
-		return fmt.Errorf("could not transition issue: %v", err)
+		return wrapError("could not transition issue", err)
 	}
+
+	to := tr.Name
+	if tr.To != nil {
+		to = tr.To.Name
+	}
+	journalMutation(jc, JournalEntry{Op: "transition", Issue: issue, Field: "status", Old: from, New: to})
 	return nil
 }
 
@@ -206,18 +673,45 @@ func SetIssueRaw(jc *Client, issueNo string, b []byte) error {
 	if err := jc.RPC("PUT", url, b, nil); err != nil {
 		return fmt.Errorf("could not set issue: %v", err)
 	}
+
+	journalMutation(jc, JournalEntry{Op: "set", Issue: issueNo, Field: "raw", New: string(b)})
 	return nil
 }
 
-func SetFieldInIssue(jc *Client, issue, field, val string) error {
+// getRawField returns the raw JSON value of a field of the issue, which is
+// null if the field is not set.
+func getRawField(jc *Client, issue, field string) (json.RawMessage, error) {
+	var res struct {
+		Fields map[string]json.RawMessage `json:"fields"`
+	}
+	u := fmt.Sprintf("/rest/api/2/issue/%s?fields=%s", issue, url.QueryEscape(field))
+	if err := jc.RPC("GET", u, nil, &res); err != nil {
+		return nil, err
+	}
+	if v, exists := res.Fields[field]; exists {
+		return v, nil
+	}
+	return json.RawMessage("null"), nil
+}
+
+func setFieldInIssue(jc *Client, issue, field, val string) error {
 	switch field {
 	case "type":
 		field = "issuetype"
+	case "fixversions":
+		field = "fixVersions"
+	case "affectsversions":
+		field = "versions"
 	}
 
 	url := fmt.Sprintf("/rest/api/2/issue/%s", issue)
 	method := "PUT"
 
+	old, err := getRawField(jc, issue, field)
+	if err != nil {
+		log.Printf("Could not get %s of %s for the journal: %v", field, issue, err)
+	}
+
 	var value interface{}
 	if val == "" {
 		value = nil
@@ -253,6 +747,19 @@ func SetFieldInIssue(jc *Client, issue, field, val string) error {
 			componentThing = append(componentThing, thing)
 		}
 		fields[field] = componentThing
+	case "fixVersions", "versions":
+		versionThing := []map[string]string{}
+		versions := strings.Split(val, "\n")
+		for _, s := range versions {
+			if s == "" {
+				continue
+			}
+			thing := map[string]string{
+				"name": s,
+			}
+			versionThing = append(versionThing, thing)
+		}
+		fields[field] = versionThing
 	case "issuetype", "assignee", "reporter", "creator", "priority", "resolution":
 		fields[field] = map[string]interface{}{
 			"name": value,
@@ -262,20 +769,546 @@ func SetFieldInIssue(jc *Client, issue, field, val string) error {
 	}
 
 	if err := jc.RPC(method, url, post, nil); err != nil {
-		return fmt.Errorf("could not set field for issue: %v", err)
+		return wrapError("could not set field for issue", err)
 	}
+
+	e := JournalEntry{Op: "set", Issue: issue, Field: field, New: val}
+	if old != nil {
+		e.Old = journalValue(old)
+		e.Revert = newRevert("PUT", url, map[string]interface{}{
+			"fields": map[string]json.RawMessage{field: old},
+		})
+	}
+	journalMutation(jc, e)
 	return nil
 }
 
+type Version struct {
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Project     string `json:"project,omitempty"`
+	Archived    bool   `json:"archived"`
+	Released    bool   `json:"released"`
+	ReleaseDate string `json:"releaseDate,omitempty"`
+}
+
+type IssueVersions struct {
+	Fields struct {
+		FixVersions []Version `json:"fixVersions"`
+		Versions    []Version `json:"versions"`
+	} `json:"fields"`
+}
+
+func GetVersionsForIssue(jc *Client, issue string) (*IssueVersions, error) {
+	var iv IssueVersions
+	url := fmt.Sprintf("/rest/api/2/issue/%s?fields=fixVersions,versions", issue)
+	if err := jc.RPC("GET", url, nil, &iv); err != nil {
+		return nil, fmt.Errorf("could not get versions for issue: %v", err)
+	}
+	return &iv, nil
+}
+
+func GetVersionsForProject(jc *Client, projectKey string) ([]Version, error) {
+	var versions []Version
+	url := fmt.Sprintf("/rest/api/2/project/%s/versions", projectKey)
+	if err := jc.RPC("GET", url, nil, &versions); err != nil {
+		return nil, fmt.Errorf("could not query versions: %v", err)
+	}
+	return versions, nil
+}
+
+func GetVersionByName(jc *Client, projectKey, name string) (*Version, error) {
+	versions, err := GetVersionsForProject(jc, projectKey)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range versions {
+		if versions[i].Name == name {
+			return &versions[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("no such version: %s", name)
+}
+
+func CreateVersion(jc *Client, projectKey, name string) error {
+	v := Version{
+		Name:    name,
+		Project: projectKey,
+	}
+	var created Version
+	if err := jc.RPC("POST", "/rest/api/2/version", v, &created); err != nil {
+		return fmt.Errorf("could not create version: %v", err)
+	}
+
+	journalCreate(jc, "version", created.ID, name)
+	return nil
+}
+
+// UpdateVersion updates only the provided fields of a version, as sending a
+// complete Version would reset the fields we do not know about.
+func UpdateVersion(jc *Client, id string, fields map[string]interface{}) error {
+	url := fmt.Sprintf("/rest/api/2/version/%s", id)
+	old := getRawObject(jc, url)
+	if err := jc.RPC("PUT", url, fields, nil); err != nil {
+		return fmt.Errorf("could not update version: %v", err)
+	}
+
+	journalUpdate(jc, "version/"+id, url, old, fields)
+	return nil
+}
+
+func MergeVersion(jc *Client, fromID, toID string) error {
+	url := fmt.Sprintf("/rest/api/2/version/%s/mergeto/%s", fromID, toID)
+	if err := jc.RPC("PUT", url, nil, nil); err != nil {
+		return fmt.Errorf("could not merge version: %v", err)
+	}
+
+	journalMutation(jc, JournalEntry{Op: "merge", Issue: "version/" + fromID, New: "version/" + toID})
+	return nil
+}
+
+// getRawObject returns the fields of the version, component or filter at
+// url, or nil if it could not be fetched.
+func getRawObject(jc *Client, url string) map[string]json.RawMessage {
+	var obj map[string]json.RawMessage
+	if err := jc.RPC("GET", url, nil, &obj); err != nil {
+		log.Printf("Could not get %s for the journal: %v", url, err)
+		return nil
+	}
+	return obj
+}
+
+// journalCreate records the creation of a version, component or filter,
+// which is reverted by deleting it.
+func journalCreate(jc *Client, kind, id, name string) {
+	journalMutation(jc, JournalEntry{
+		Op:     "create",
+		Issue:  kind + "/" + id,
+		New:    name,
+		Revert: newRevert("DELETE", fmt.Sprintf("/rest/api/2/%s/%s", kind, id), nil),
+	})
+}
+
+// journalUpdate records the update of the fields of the version, component
+// or filter at url, where old holds its fields before the update. It is
+// reverted by writing back the old values of the updated fields.
+func journalUpdate(jc *Client, object, url string, old map[string]json.RawMessage, fields map[string]interface{}) {
+	var keys []string
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	e := JournalEntry{Op: "set", Issue: object, Field: strings.Join(keys, ",")}
+	if b, err := json.Marshal(fields); err == nil {
+		e.New = string(b)
+	}
+	if old != nil {
+		prev := make(map[string]json.RawMessage)
+		for _, k := range keys {
+			if v, exists := old[k]; exists {
+				prev[k] = v
+			} else {
+				prev[k] = json.RawMessage("null")
+			}
+		}
+		if b, err := json.Marshal(prev); err == nil {
+			e.Old = string(b)
+		}
+		e.Revert = newRevert("PUT", url, prev)
+	}
+	journalMutation(jc, e)
+}
+
+type ComponentUser struct {
+	Name string `json:"name,omitempty"`
+}
+
+type Component struct {
+	ID           string         `json:"id,omitempty"`
+	Name         string         `json:"name,omitempty"`
+	Description  string         `json:"description,omitempty"`
+	Project      string         `json:"project,omitempty"`
+	Lead         *ComponentUser `json:"lead,omitempty"`
+	AssigneeType string         `json:"assigneeType,omitempty"`
+	RealAssignee *ComponentUser `json:"realAssignee,omitempty"`
+}
+
+func GetComponentsForProject(jc *Client, projectKey string) ([]Component, error) {
+	var components []Component
+	url := fmt.Sprintf("/rest/api/2/project/%s/components", projectKey)
+	if err := jc.RPC("GET", url, nil, &components); err != nil {
+		return nil, fmt.Errorf("could not query components: %v", err)
+	}
+	return components, nil
+}
+
+func GetComponentByName(jc *Client, projectKey, name string) (*Component, error) {
+	components, err := GetComponentsForProject(jc, projectKey)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range components {
+		if components[i].Name == name {
+			return &components[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("no such component: %s", name)
+}
+
+func CreateComponent(jc *Client, projectKey, name string) error {
+	c := Component{
+		Name:    name,
+		Project: projectKey,
+	}
+	var created Component
+	if err := jc.RPC("POST", "/rest/api/2/component", c, &created); err != nil {
+		return fmt.Errorf("could not create component: %v", err)
+	}
+
+	journalCreate(jc, "component", created.ID, name)
+	return nil
+}
+
+// UpdateComponent updates only the provided fields of a component.
+func UpdateComponent(jc *Client, id string, fields map[string]interface{}) error {
+	url := fmt.Sprintf("/rest/api/2/component/%s", id)
+	old := getRawObject(jc, url)
+	if err := jc.RPC("PUT", url, fields, nil); err != nil {
+		return fmt.Errorf("could not update component: %v", err)
+	}
+
+	journalUpdate(jc, "component/"+id, url, old, fields)
+	return nil
+}
+
+// DeleteComponent deletes the component. The deletion is journaled, but
+// cannot be undone, as a recreated component would not be set on the issues
+// that had it.
+func DeleteComponent(jc *Client, id string) error {
+	url := fmt.Sprintf("/rest/api/2/component/%s", id)
+	var old []byte
+	if err := jc.RPC("GET", url, nil, &old); err != nil {
+		log.Printf("Could not get %s for the journal: %v", url, err)
+	}
+	if err := jc.RPC("DELETE", url, nil, nil); err != nil {
+		return fmt.Errorf("could not delete component: %v", err)
+	}
+
+	journalMutation(jc, JournalEntry{Op: "delete", Issue: "component/" + id, Old: string(old)})
+	return nil
+}
+
+type Field struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Custom bool   `json:"custom"`
+	Schema struct {
+		Type   string `json:"type"`
+		Custom string `json:"custom"`
+	} `json:"schema"`
+}
+
+func GetFields(jc *Client) ([]Field, error) {
+	var fields []Field
+	if err := jc.RPC("GET", "/rest/api/2/field", nil, &fields); err != nil {
+		return nil, fmt.Errorf("could not query fields: %v", err)
+	}
+	return fields, nil
+}
+
+const epicLinkSchema = "com.pyxis.greenhopper.jira:gh-epic-link"
+
+// GetEpicLinkField returns the id of the epic link custom field, such as
+// "customfield_10008". The field is discovered on first use and cached.
+func GetEpicLinkField(jc *Client) (string, error) {
+	jc.fieldLock.Lock()
+	defer jc.fieldLock.Unlock()
+
+	jc.metrics.CacheLookup("epiclink", jc.epicLinkField != "")
+	if jc.epicLinkField != "" {
+		return jc.epicLinkField, nil
+	}
+
+	fields, err := GetFields(jc)
+	if err != nil {
+		return "", err
+	}
+
+	for _, f := range fields {
+		if f.Schema.Custom == epicLinkSchema {
+			jc.epicLinkField = f.ID
+			return f.ID, nil
+		}
+	}
+
+	return "", errors.New("no epic link field found")
+}
+
+// EpicChildrenQuery returns the JQL query for the issues in an epic.
+func EpicChildrenQuery(jc *Client, epic string) (string, error) {
+	field, err := GetEpicLinkField(jc)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("cf[%s] = %s", strings.TrimPrefix(field, "customfield_"), epic), nil
+}
+
+// jqlTimeLayout is the format of dates in JQL queries.
+const jqlTimeLayout = "2006/01/02 15:04"
+
+// GetUserZone returns the time zone of the JIRA user, which is discovered
+// on first use.
+func GetUserZone(jc *Client) (*time.Location, error) {
+	jc.zoneLock.Lock()
+	defer jc.zoneLock.Unlock()
+
+	jc.metrics.CacheLookup("timezone", jc.zone != nil)
+	if jc.zone != nil {
+		return jc.zone, nil
+	}
+
+	var myself struct {
+		TimeZone string `json:"timeZone"`
+	}
+	if err := jc.RPC("GET", "/rest/api/2/myself", nil, &myself); err != nil {
+		return nil, wrapError("could not get user", err)
+	}
+
+	zone, err := time.LoadLocation(myself.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("could not load time zone %s: %v", myself.TimeZone, err)
+	}
+	jc.zone = zone
+	return zone, nil
+}
+
+// jqlTime formats t as a JQL date. JIRA interprets JQL dates in the time
+// zone of the user, rather than that of jirafs.
+func jqlTime(jc *Client, t time.Time) (string, error) {
+	zone, err := GetUserZone(jc)
+	if err != nil {
+		return "", err
+	}
+	return t.In(zone).Format(jqlTimeLayout), nil
+}
+
+// UpdateLabels adds and removes labels from an issue, leaving other labels
+// untouched.
+func UpdateLabels(jc *Client, issue string, add, remove []string) error {
+	var ops []interface{}
+	for _, l := range add {
+		ops = append(ops, map[string]string{"add": l})
+	}
+	for _, l := range remove {
+		ops = append(ops, map[string]string{"remove": l})
+	}
+
+	post := map[string]interface{}{
+		"update": map[string]interface{}{
+			"labels": ops,
+		},
+	}
+	url := fmt.Sprintf("/rest/api/2/issue/%s", issue)
+
+	old, err := getRawField(jc, issue, "labels")
+	if err != nil {
+		log.Printf("Could not get labels of %s for the journal: %v", issue, err)
+	}
+
+	if err := jc.RPC("PUT", url, post, nil); err != nil {
+		return fmt.Errorf("could not update labels: %v", err)
+	}
+
+	var change []string
+	for _, l := range add {
+		change = append(change, "+"+l)
+	}
+	for _, l := range remove {
+		change = append(change, "-"+l)
+	}
+	e := JournalEntry{Op: "labels", Issue: issue, Field: "labels", New: strings.Join(change, " ")}
+	if old != nil {
+		e.Old = journalValue(old)
+		e.Revert = newRevert("PUT", url, map[string]interface{}{
+			"fields": map[string]json.RawMessage{"labels": old},
+		})
+	}
+	journalMutation(jc, e)
+	return nil
+}
+
+type Filter struct {
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	JQL         string `json:"jql,omitempty"`
+	Favourite   bool   `json:"favourite,omitempty"`
+}
+
+func GetFavouriteFilters(jc *Client) ([]Filter, error) {
+	var filters []Filter
+	if err := jc.RPC("GET", "/rest/api/2/filter/favourite", nil, &filters); err != nil {
+		return nil, fmt.Errorf("could not query filters: %v", err)
+	}
+	return filters, nil
+}
+
+func GetFilter(jc *Client, id string) (*Filter, error) {
+	var f Filter
+	url := fmt.Sprintf("/rest/api/2/filter/%s", id)
+	if err := jc.RPC("GET", url, nil, &f); err != nil {
+		return nil, fmt.Errorf("could not query filter: %v", err)
+	}
+	return &f, nil
+}
+
+func CreateFilter(jc *Client, name, jql string) (*Filter, error) {
+	f := Filter{
+		Name:      name,
+		JQL:       jql,
+		Favourite: true,
+	}
+	var created Filter
+	if err := jc.RPC("POST", "/rest/api/2/filter", f, &created); err != nil {
+		return nil, fmt.Errorf("could not create filter: %v", err)
+	}
+
+	journalCreate(jc, "filter", created.ID, name)
+	return &created, nil
+}
+
+// UpdateFilter updates only the provided fields of a filter.
+func UpdateFilter(jc *Client, id string, fields map[string]interface{}) error {
+	url := fmt.Sprintf("/rest/api/2/filter/%s", id)
+	old := getRawObject(jc, url)
+	if err := jc.RPC("PUT", url, fields, nil); err != nil {
+		return fmt.Errorf("could not update filter: %v", err)
+	}
+
+	journalUpdate(jc, "filter/"+id, url, old, fields)
+	return nil
+}
+
+type CreateMetaIssueType struct {
+	ID      string               `json:"id"`
+	Name    string               `json:"name"`
+	Subtask bool                 `json:"subtask"`
+	Fields  map[string]FieldMeta `json:"fields"`
+}
+
+type CreateMeta struct {
+	Projects []struct {
+		Key        string                `json:"key"`
+		IssueTypes []CreateMetaIssueType `json:"issuetypes"`
+	} `json:"projects"`
+}
+
+// GetCreateMeta returns the issue types of the project, with the fields of
+// their create screens.
+func GetCreateMeta(jc *Client, projectKey string) ([]CreateMetaIssueType, error) {
+	var cm CreateMeta
+	url := fmt.Sprintf("/rest/api/2/issue/createmeta?projectKeys=%s&expand=projects.issuetypes.fields", projectKey)
+	if err := jc.RPC("GET", url, nil, &cm); err != nil {
+		return nil, fmt.Errorf("could not query createmeta: %v", err)
+	}
+	if len(cm.Projects) == 0 {
+		return nil, fmt.Errorf("no createmeta for project %s", projectKey)
+	}
+	return cm.Projects[0].IssueTypes, nil
+}
+
+type BulkCreateResult struct {
+	Issues []CreateIssueResult `json:"issues"`
+	Errors []struct {
+		Status        int `json:"status"`
+		ElementErrors struct {
+			ErrorMessages []string          `json:"errorMessages"`
+			Errors        map[string]string `json:"errors"`
+		} `json:"elementErrors"`
+		FailedElementNumber int `json:"failedElementNumber"`
+	} `json:"errors"`
+}
+
+// CreateIssues creates issues from the fields of each issue in a single
+// request. It returns the key or error of each issue, in order.
+func CreateIssues(jc *Client, issues []map[string]interface{}) ([]string, []error, error) {
+	var updates []interface{}
+	for _, fields := range issues {
+		updates = append(updates, map[string]interface{}{"fields": fields})
+	}
+
+	var bcr BulkCreateResult
+	post := map[string]interface{}{"issueUpdates": updates}
+	err := jc.RPC("POST", "/rest/api/2/issue/bulk", post, &bcr)
+	if rpcErr, ok := err.(*RPCError); ok {
+		// Partial failures are reported with an error status, but with the
+		// result in the body. Other failures have no element errors.
+		if json.Unmarshal(rpcErr.Body, &bcr) != nil || len(bcr.Errors) == 0 {
+			return nil, nil, fmt.Errorf("could not create issues: %v", err)
+		}
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("could not create issues: %v", err)
+	}
+
+	keys := make([]string, len(issues))
+	errs := make([]error, len(issues))
+	for _, e := range bcr.Errors {
+		if e.FailedElementNumber < 0 || e.FailedElementNumber >= len(issues) {
+			continue
+		}
+		msgs := e.ElementErrors.ErrorMessages
+		for field, msg := range e.ElementErrors.Errors {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", field, msg))
+		}
+		errs[e.FailedElementNumber] = errors.New(strings.Join(msgs, "; "))
+	}
+
+	// The created issues are listed in order, skipping the failed ones.
+	created := bcr.Issues
+	for i := range issues {
+		if errs[i] != nil {
+			continue
+		}
+		if len(created) == 0 {
+			errs[i] = errors.New("issue missing from result")
+			continue
+		}
+		keys[i] = created[0].Key
+		created = created[1:]
+
+		summary, _ := issues[i]["summary"].(string)
+		journalMutation(jc, JournalEntry{Op: "create", Issue: keys[i], New: summary})
+	}
+
+	return keys, errs, nil
+}
+
 type CommentResult struct {
 	Comments []jira.Comment `json:"comments,omitempty"`
 }
 
 func GetCommentsForIssue(jc *Client, issue string) ([]string, error) {
-	var cr CommentResult
+	cr := &CommentResult{}
 	url := fmt.Sprintf("/rest/api/2/issue/%s/comment?maxResults=1000", issue)
-	if err := jc.RPC("GET", url, nil, &cr); err != nil {
-		return nil, fmt.Errorf("could not get comments: %v", err)
+	if jc.Offline() {
+		var err error
+		if cr, err = jc.mirror.Comments(issue); err != nil {
+			return nil, fmt.Errorf("could not get comments: %v", err)
+		}
+	} else if err := jc.RPC("GET", url, nil, cr); err != nil {
+		if !IsNetworkError(err) {
+			return nil, fmt.Errorf("could not get comments: %v", err)
+		}
+		if cr, err = jc.mirror.Comments(issue); err != nil {
+			return nil, fmt.Errorf("could not get comments: %v", err)
+		}
 	}
 
 	var ss []string
@@ -287,9 +1320,18 @@ func GetCommentsForIssue(jc *Client, issue string) ([]string, error) {
 }
 
 func GetComment(jc *Client, issue, id string) (*jira.Comment, error) {
+	if jc.Offline() {
+		return jc.mirror.Comment(issue, id)
+	}
+
 	var c jira.Comment
 	url := fmt.Sprintf("/rest/api/2/issue/%s/comment/%s", issue, id)
 	if err := jc.RPC("GET", url, nil, &c); err != nil {
+		if IsNetworkError(err) {
+			if mc, merr := jc.mirror.Comment(issue, id); merr == nil {
+				return mc, nil
+			}
+		}
 		return nil, fmt.Errorf("could not get comment: %v", err)
 	}
 	return &c, nil
@@ -300,31 +1342,109 @@ func SetComment(jc *Client, issue, id, body string) error {
 		Body: body,
 	}
 	url := fmt.Sprintf("/rest/api/2/issue/%s/comment/%s", issue, id)
+
+	var old jira.Comment
+	oldErr := jc.RPC("GET", url, nil, &old)
+	if oldErr != nil {
+		log.Printf("Could not get comment %s of %s for the journal: %v", id, issue, oldErr)
+	}
+
 	if err := jc.RPC("PUT", url, c, nil); err != nil {
 		return fmt.Errorf("could not set comment: %v", err)
 	}
+
+	e := JournalEntry{Op: "set", Issue: issue, Field: "comments/" + id, New: body}
+	if oldErr == nil {
+		e.Old = old.Body
+		e.Revert = newRevert("PUT", url, jira.Comment{Body: old.Body})
+	}
+	journalMutation(jc, e)
 	return nil
 }
 
-func AddComment(jc *Client, issue, body string) error {
+func addComment(jc *Client, issue, body string) error {
 	c := jira.Comment{
 		Body: body,
 	}
+	var created jira.Comment
 	url := fmt.Sprintf("/rest/api/2/issue/%s/comment/", issue)
-	if err := jc.RPC("POST", url, c, nil); err != nil {
-		return fmt.Errorf("could not add comment: %v", err)
+	if err := jc.RPC("POST", url, c, &created); err != nil {
+		return wrapError("could not add comment", err)
 	}
+
+	journalMutation(jc, JournalEntry{
+		Op:     "create",
+		Issue:  issue,
+		Field:  "comments/" + created.ID,
+		New:    body,
+		Revert: newRevert("DELETE", url+created.ID, nil),
+	})
 	return nil
 }
 
+// RemoveComment deletes the comment. Undoing the deletion adds the comment
+// again, under a new id.
 func RemoveComment(jc *Client, issue, id string) error {
 	url := fmt.Sprintf("/rest/api/2/issue/%s/comment/%s", issue, id)
+
+	var old jira.Comment
+	oldErr := jc.RPC("GET", url, nil, &old)
+	if oldErr != nil {
+		log.Printf("Could not get comment %s of %s for the journal: %v", id, issue, oldErr)
+	}
+
 	if err := jc.RPC("DELETE", url, nil, nil); err != nil {
 		return fmt.Errorf("could not delete comment: %v", err)
 	}
+
+	e := JournalEntry{Op: "delete", Issue: issue, Field: "comments/" + id}
+	if oldErr == nil {
+		e.Old = old.Body
+		e.Revert = newRevert("POST", fmt.Sprintf("/rest/api/2/issue/%s/comment/", issue), jira.Comment{Body: old.Body})
+	}
+	journalMutation(jc, e)
 	return nil
 }
 
+// JQLQuote quotes a string for use as a value in a JQL query.
+func JQLQuote(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "\"", "\\\"", -1)
+	return "\"" + s + "\""
+}
+
+// SplitOrderBy splits a JQL query into the query and the ORDER BY clause,
+// without the ORDER BY keywords. ORDER BY inside quoted values is ignored.
+func SplitOrderBy(jql string) (query, order string) {
+	var quote rune
+	escaped := false
+	for i, r := range jql {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quote != 0:
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == 'o' || r == 'O':
+			if i > 0 && !unicode.IsSpace(rune(jql[i-1])) && jql[i-1] != ')' {
+				continue
+			}
+			fields := strings.Fields(jql[i:])
+			if len(fields) < 2 || !strings.EqualFold(fields[0], "order") || !strings.EqualFold(fields[1], "by") {
+				continue
+			}
+			rest := strings.TrimSpace(jql[i+len("order"):])
+			return strings.TrimSpace(jql[:i]), strings.TrimSpace(rest[len("by"):])
+		}
+	}
+	return strings.TrimSpace(jql), ""
+}
+
 func StringsToStats(strs []string, Perm qp.FileMode, user, group string) []qp.Stat {
 	var stats []qp.Stat
 	for _, str := range strs {
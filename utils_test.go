@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitQuoted(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+		err  bool
+	}{
+		{"", nil, false},
+		{"  ", nil, false},
+		{"a b  c", []string{"a", "b", "c"}, false},
+		{"a\tb\nc", []string{"a", "b", "c"}, false},
+		{`a "b c" d`, []string{"a", "b c", "d"}, false},
+		{`field="some text"`, []string{"field=some text"}, false},
+		{`""`, []string{""}, false},
+		{`"a \"b\" c"`, []string{`a "b" c`}, false},
+		{`a\b`, []string{`a\b`}, false},
+		{`"unterminated`, nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := SplitQuoted(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("SplitQuoted(%q): err = %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitQuoted(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseTransitionInput(t *testing.T) {
+	tests := []struct {
+		in     string
+		name   string
+		values map[string]string
+		err    bool
+	}{
+		{"Done", "Done", map[string]string{}, false},
+		{"Start Progress", "Start Progress", map[string]string{}, false},
+		{"Resolve Issue resolution=Fixed", "Resolve Issue", map[string]string{"resolution": "Fixed"}, false},
+		{`Close Comment="looks good" Resolution="Won't Fix"`, "Close",
+			map[string]string{"comment": "looks good", "resolution": "Won't Fix"}, false},
+		{"Done fixversions=1.0,1.1", "Done", map[string]string{"fixversions": "1.0,1.1"}, false},
+		{"Done =x", "Done =x", map[string]string{}, false},
+		{"Done a=1 stray", "", nil, true},
+		{"a=1", "", nil, true},
+		{"", "", nil, true},
+		{`Done comment="open`, "", nil, true},
+	}
+
+	for _, tt := range tests {
+		name, values, err := ParseTransitionInput(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseTransitionInput(%q): err = %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if tt.err {
+			continue
+		}
+		if name != tt.name || !reflect.DeepEqual(values, tt.values) {
+			t.Errorf("ParseTransitionInput(%q) = %q, %v, want %q, %v", tt.in, name, values, tt.name, tt.values)
+		}
+	}
+}
+
+func TestSplitOrderBy(t *testing.T) {
+	tests := []struct {
+		in, query, order string
+	}{
+		{"", "", ""},
+		{"project = ABC", "project = ABC", ""},
+		{"project = ABC ORDER BY key ASC", "project = ABC", "key ASC"},
+		{"project = ABC order  by updated DESC, key", "project = ABC", "updated DESC, key"},
+		{"ORDER BY created", "", "created"},
+		{"(project = ABC)ORDER BY key", "(project = ABC)", "key"},
+		{`summary ~ "order by" ORDER BY key`, `summary ~ "order by"`, "key"},
+		{`summary ~ 'order by'`, `summary ~ 'order by'`, ""},
+		{`summary ~ "say \"order by\"" order by key`, `summary ~ "say \"order by\""`, "key"},
+		{"reorder = 1 AND border by = 2", "reorder = 1 AND border by = 2", ""},
+		{"assignee = orderly", "assignee = orderly", ""},
+	}
+
+	for _, tt := range tests {
+		query, order := SplitOrderBy(tt.in)
+		if query != tt.query || order != tt.order {
+			t.Errorf("SplitOrderBy(%q) = %q, %q, want %q, %q", tt.in, query, order, tt.query, tt.order)
+		}
+	}
+}
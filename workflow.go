@@ -3,23 +3,35 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"log"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type thing struct {
 	Name string `json:"name"`
 }
 
-func BuildWorkflow1(jc *Client, project, issueTypeNo string) (*WorkflowGraph, error) {
+// GetWorkflowName returns the name of the workflow used for the issue type in
+// the project.
+func GetWorkflowName(jc *Client, project, issueTypeNo string) (string, error) {
 	var t thing
 	u := fmt.Sprintf("/rest/projectconfig/latest/issuetype/%s/%s/workflow", project, issueTypeNo)
 	if err := jc.RPC("GET", u, nil, &t); err != nil {
-		return nil, fmt.Errorf("could not query workflow for issue: %v", err)
+		return "", fmt.Errorf("could not query workflow for issue: %v", err)
 	}
+	return t.Name, nil
+}
 
+// BuildWorkflow1 builds the workflow graph using the projectconfig API.
+func BuildWorkflow1(jc *Client, name string) (*WorkflowGraph, error) {
 	var wr WorkflowResponse1
-	u = fmt.Sprintf("/rest/projectconfig/latest/workflow?workflowName=%s", url.QueryEscape(t.Name))
+	u := fmt.Sprintf("/rest/projectconfig/latest/workflow?workflowName=%s", url.QueryEscape(name))
 	if err := jc.RPC("GET", u, nil, &wr); err != nil {
 		return nil, fmt.Errorf("could not query workflow graph: %v", err)
 	}
@@ -29,15 +41,11 @@ func BuildWorkflow1(jc *Client, project, issueTypeNo string) (*WorkflowGraph, er
 	return &wg, nil
 }
 
-func BuildWorkflow2(jc *Client, project, issueTypeNo string) (*WorkflowGraph, error) {
-	var t thing
-	u := fmt.Sprintf("/rest/projectconfig/latest/issuetype/%s/%s/workflow", project, issueTypeNo)
-	if err := jc.RPC("GET", u, nil, &t); err != nil {
-		return nil, fmt.Errorf("could not query workflow for issue: %v", err)
-	}
-
+// BuildWorkflow2 builds the workflow graph using the workflowDesigner plugin
+// API.
+func BuildWorkflow2(jc *Client, name string) (*WorkflowGraph, error) {
 	var wr WorkflowResponse2
-	u = fmt.Sprintf("/rest/workflowDesigner/latest/workflows?name=%s", url.QueryEscape(t.Name))
+	u := fmt.Sprintf("/rest/workflowDesigner/latest/workflows?name=%s", url.QueryEscape(name))
 	if err := jc.RPC("GET", u, nil, &wr); err != nil {
 		return nil, fmt.Errorf("could not query workflow graph: %v", err)
 	}
@@ -47,6 +55,254 @@ func BuildWorkflow2(jc *Client, project, issueTypeNo string) (*WorkflowGraph, er
 	return &wg, nil
 }
 
+// BuildWorkflowFromProbes builds a partial workflow graph from the transitions
+// available on issues of the issue type in the project, probing one issue per
+// status. If issueKey is not empty, that issue is probed as well. The graph
+// only contains transitions available to the current user on the probed
+// issues.
+func BuildWorkflowFromProbes(jc *Client, project, issueTypeNo, issueKey string) (*WorkflowGraph, error) {
+	var s SearchResult
+	query := fmt.Sprintf("project = %s AND issuetype = %s", project, issueTypeNo)
+	u := fmt.Sprintf("/rest/api/2/search?fields=status&maxResults=%d&jql=%s", jc.maxlisting, url.QueryEscape(query))
+	if err := jc.RPC("GET", u, nil, &s); err != nil {
+		return nil, fmt.Errorf("could not search for issues to probe: %v", err)
+	}
+
+	probes := make(map[string]string)
+	if issueKey != "" {
+		issue, err := GetIssue(jc, issueKey)
+		if err != nil {
+			return nil, err
+		}
+		if issue.Fields != nil && issue.Fields.Status != nil {
+			probes[issue.Fields.Status.ID] = issue.Key
+		}
+	}
+
+	for _, issue := range s.Issues {
+		if issue.Fields == nil || issue.Fields.Status == nil {
+			continue
+		}
+		if _, exists := probes[issue.Fields.Status.ID]; !exists {
+			probes[issue.Fields.Status.ID] = issue.Key
+		}
+	}
+
+	var wg WorkflowGraph
+	for _, key := range probes {
+		issue, err := GetIssue(jc, key)
+		if err != nil {
+			return nil, err
+		}
+		if issue.Fields == nil || issue.Fields.Status == nil {
+			continue
+		}
+
+		trs, err := GetTransitionsForIssue(jc, key)
+		if err != nil {
+			return nil, err
+		}
+
+		from := &WorkflowStatus{
+			Name:        issue.Fields.Status.Name,
+			ID:          issue.Fields.Status.ID,
+			Description: issue.Fields.Status.Description,
+		}
+		wg.BuildProbe(from, trs)
+	}
+
+	if len(wg.verteces) == 0 {
+		return nil, errors.New("no issues to probe")
+	}
+
+	return &wg, nil
+}
+
+// probeTTL is how long partial graphs built from transition probes are
+// cached. Probes only see the transitions available on the probed issues, so
+// they are refreshed more often than complete graphs.
+const probeTTL = time.Minute
+
+// getWorkflowProbe returns the partial workflow graph built from transition
+// probes, which is cached for probeTTL.
+func getWorkflowProbe(jc *Client, project, issueTypeNo, issueKey string) (*WorkflowGraph, error) {
+	key := fmt.Sprintf("probe:%s/%s", project, issueTypeNo)
+	wg := jc.workflows.Get(key)
+	jc.metrics.CacheLookup("workflow", wg != nil)
+	if wg != nil {
+		return wg, nil
+	}
+
+	wg, err := BuildWorkflowFromProbes(jc, project, issueTypeNo, issueKey)
+	if err != nil {
+		return nil, err
+	}
+	jc.workflows.PutTTL(key, wg, probeTTL)
+	return wg, nil
+}
+
+// GetWorkflow returns the workflow graph for the issue type in the project.
+// Graphs are cached per workflow name, and the workflow name is cached per
+// project and issue type. The workflowDesigner API is tried first, falling
+// back to the projectconfig API, and finally to a partial graph built from
+// transition probes. Partial graphs are never cached under the workflow name.
+// issueKey is an optional issue to include in the probes.
+func GetWorkflow(jc *Client, project, issueTypeNo, issueKey string) (*WorkflowGraph, error) {
+	nameKey := fmt.Sprintf("%s/%s", project, issueTypeNo)
+	name, cached := jc.workflows.Name(nameKey)
+	jc.metrics.CacheLookup("workflow-name", cached)
+	if !cached {
+		var err error
+		name, err = GetWorkflowName(jc, project, issueTypeNo)
+		if err != nil {
+			log.Printf("Could not get workflow name, probing transitions: %v", err)
+			return getWorkflowProbe(jc, project, issueTypeNo, issueKey)
+		}
+		jc.workflows.PutName(nameKey, name)
+	}
+
+	wg := jc.workflows.Get(name)
+	jc.metrics.CacheLookup("workflow", wg != nil)
+	if wg != nil {
+		return wg, nil
+	}
+
+	wg, err := BuildWorkflow2(jc, name)
+	if err != nil {
+		log.Printf("Could not use workflowDesigner API, trying projectconfig API: %v", err)
+		wg, err = BuildWorkflow1(jc, name)
+	}
+	if err != nil {
+		log.Printf("Could not use projectconfig API, probing transitions: %v", err)
+		return getWorkflowProbe(jc, project, issueTypeNo, issueKey)
+	}
+
+	jc.workflows.Put(name, wg)
+	return wg, nil
+}
+
+// InvalidateWorkflow removes the cached workflow graph and workflow name for
+// the issue type in the project, if any.
+func InvalidateWorkflow(jc *Client, project, issueTypeNo string) {
+	nameKey := fmt.Sprintf("%s/%s", project, issueTypeNo)
+	jc.workflows.Invalidate(fmt.Sprintf("probe:%s", nameKey))
+	if name, cached := jc.workflows.Name(nameKey); cached {
+		jc.workflows.Invalidate(name)
+	} else if name, err := GetWorkflowName(jc, project, issueTypeNo); err == nil {
+		jc.workflows.Invalidate(name)
+	}
+	jc.workflows.InvalidateName(nameKey)
+}
+
+type cachedWorkflow struct {
+	graph   *WorkflowGraph
+	name    string
+	fetched time.Time
+	ttl     time.Duration
+}
+
+// WorkflowCache stores workflow graphs by name, and workflow names by project
+// and issue type, for a limited time. The zero value is an empty cache with no
+// expiry.
+type WorkflowCache struct {
+	sync.Mutex
+	ttl    time.Duration
+	graphs map[string]cachedWorkflow
+	names  map[string]cachedWorkflow
+}
+
+func (wc *WorkflowCache) SetTTL(ttl time.Duration) {
+	wc.Lock()
+	defer wc.Unlock()
+	wc.ttl = ttl
+}
+
+// expired reports whether the entry has outlived its own TTL, or the cache TTL
+// if it has none.
+func (wc *WorkflowCache) expired(cw cachedWorkflow) bool {
+	ttl := cw.ttl
+	if ttl == 0 {
+		ttl = wc.ttl
+	}
+	return ttl > 0 && time.Since(cw.fetched) > ttl
+}
+
+// Get returns the cached graph, or nil if the graph is not cached or expired.
+func (wc *WorkflowCache) Get(name string) *WorkflowGraph {
+	wc.Lock()
+	defer wc.Unlock()
+
+	cw, exists := wc.graphs[name]
+	if !exists {
+		return nil
+	}
+	if wc.expired(cw) {
+		delete(wc.graphs, name)
+		return nil
+	}
+	return cw.graph
+}
+
+func (wc *WorkflowCache) Put(name string, wg *WorkflowGraph) {
+	wc.PutTTL(name, wg, 0)
+}
+
+// PutTTL caches the graph with its own TTL. A zero TTL uses the cache TTL.
+func (wc *WorkflowCache) PutTTL(name string, wg *WorkflowGraph, ttl time.Duration) {
+	wc.Lock()
+	defer wc.Unlock()
+	if wc.graphs == nil {
+		wc.graphs = make(map[string]cachedWorkflow)
+	}
+	wc.graphs[name] = cachedWorkflow{graph: wg, fetched: time.Now(), ttl: ttl}
+}
+
+func (wc *WorkflowCache) Invalidate(name string) {
+	wc.Lock()
+	defer wc.Unlock()
+	delete(wc.graphs, name)
+}
+
+// Name returns the cached workflow name for the key, and whether it was
+// cached and not expired.
+func (wc *WorkflowCache) Name(key string) (string, bool) {
+	wc.Lock()
+	defer wc.Unlock()
+
+	cw, exists := wc.names[key]
+	if !exists {
+		return "", false
+	}
+	if wc.expired(cw) {
+		delete(wc.names, key)
+		return "", false
+	}
+	return cw.name, true
+}
+
+func (wc *WorkflowCache) PutName(key, name string) {
+	wc.Lock()
+	defer wc.Unlock()
+	if wc.names == nil {
+		wc.names = make(map[string]cachedWorkflow)
+	}
+	wc.names[key] = cachedWorkflow{name: name, fetched: time.Now()}
+}
+
+func (wc *WorkflowCache) InvalidateName(key string) {
+	wc.Lock()
+	defer wc.Unlock()
+	delete(wc.names, key)
+}
+
+// InvalidateAll empties the cache.
+func (wc *WorkflowCache) InvalidateAll() {
+	wc.Lock()
+	defer wc.Unlock()
+	wc.graphs = nil
+	wc.names = nil
+}
+
 type WorkflowResponse2 struct {
 	Layout struct {
 		Statuses []struct {
@@ -178,7 +434,7 @@ func (wg *WorkflowGraph) Build1(wr *WorkflowResponse1) {
 			targetStatus, exists := wg.verteces[targetName]
 			if !exists {
 				targetStatus = target.ToStatus.Status()
-				wg.verteces[name] = targetStatus
+				wg.verteces[targetName] = targetStatus
 			}
 			targetEdge := StatusEdge{
 				Name:   target.TransitionName,
@@ -190,6 +446,37 @@ func (wg *WorkflowGraph) Build1(wr *WorkflowResponse1) {
 	}
 }
 
+// BuildProbe adds the transitions available from a status to the graph.
+func (wg *WorkflowGraph) BuildProbe(from *WorkflowStatus, trs []Transition) {
+	if wg.verteces == nil {
+		wg.verteces = make(map[string]*Status)
+	}
+
+	name := strings.ToLower(from.Name)
+	fromStatus, exists := wg.verteces[name]
+	if !exists {
+		fromStatus = from.Status()
+		wg.verteces[name] = fromStatus
+	}
+
+	for _, tr := range trs {
+		if tr.To == nil {
+			continue
+		}
+		targetName := strings.ToLower(tr.To.Name)
+		targetStatus, exists := wg.verteces[targetName]
+		if !exists {
+			targetStatus = tr.To.Status()
+			wg.verteces[targetName] = targetStatus
+		}
+
+		fromStatus.Edges = append(fromStatus.Edges, StatusEdge{
+			Name:   tr.Name,
+			Status: targetStatus,
+		})
+	}
+}
+
 func (wg *WorkflowGraph) Dump() string {
 	var ss string
 	for _, v := range wg.verteces {
@@ -203,17 +490,205 @@ func (wg *WorkflowGraph) Dump() string {
 	return ss
 }
 
-type path struct {
-	from *path
-	edge StatusEdge
+// sorted returns the verteces of the graph sorted by name.
+func (wg *WorkflowGraph) sorted() []*Status {
+	var statuses []*Status
+	for _, v := range wg.verteces {
+		statuses = append(statuses, v)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// Statuses returns the names of all statuses in the workflow, sorted by name.
+func (wg *WorkflowGraph) Statuses() []string {
+	var ss []string
+	for _, v := range wg.sorted() {
+		ss = append(ss, v.Name)
+	}
+	return ss
+}
+
+// Transitions renders all edges of the workflow, one per line, in the form
+// "From -> To: Transition name".
+func (wg *WorkflowGraph) Transitions() string {
+	var s string
+	for _, v := range wg.sorted() {
+		for _, e := range v.Edges {
+			s += fmt.Sprintf("%s -> %s: %s\n", v.Name, e.Status.Name, e.Name)
+		}
+	}
+	return s
+}
+
+// Dot renders the workflow in the graphviz DOT format.
+func (wg *WorkflowGraph) Dot() string {
+	s := "digraph workflow {\n"
+	for _, v := range wg.sorted() {
+		s += fmt.Sprintf("	%q;\n", v.Name)
+	}
+	for _, v := range wg.sorted() {
+		for _, e := range v.Edges {
+			s += fmt.Sprintf("	%q -> %q [label=%q];\n", v.Name, e.Status.Name, e.Name)
+		}
+	}
+	return s + "}\n"
+}
+
+// PathCosts holds the configured costs of passing through transitions and
+// statuses, by case insensitive name. Every transition has a base cost of 1.
+// The zero value has no additional costs.
+type PathCosts struct {
+	sync.Mutex
+	transitions map[string]int
+	statuses    map[string]int
+}
+
+func (pc *PathCosts) SetTransition(name string, cost int) {
+	pc.Lock()
+	defer pc.Unlock()
+	if pc.transitions == nil {
+		pc.transitions = make(map[string]int)
+	}
+	pc.transitions[strings.ToLower(name)] = cost
+}
+
+func (pc *PathCosts) SetStatus(name string, cost int) {
+	pc.Lock()
+	defer pc.Unlock()
+	if pc.statuses == nil {
+		pc.statuses = make(map[string]int)
+	}
+	pc.statuses[strings.ToLower(name)] = cost
 }
 
-// Path finds the shortest path in the workflow graph from A to B, searching at
+// Set parses cost arguments in the form "transition|status cost name", where
+// the name may contain spaces.
+func (pc *PathCosts) Set(args []string) error {
+	if len(args) < 3 {
+		return errors.New("invalid arguments")
+	}
+
+	cost, err := strconv.Atoi(args[1])
+	if err != nil {
+		return err
+	}
+	if cost < 0 {
+		return errors.New("cost must not be negative")
+	}
+
+	name := strings.Join(args[2:], " ")
+	switch args[0] {
+	case "transition":
+		pc.SetTransition(name, cost)
+	case "status":
+		pc.SetStatus(name, cost)
+	default:
+		return fmt.Errorf("unknown cost kind: %s", args[0])
+	}
+	return nil
+}
+
+// Load reads costs from a file, one per line in the format accepted by Set.
+// Empty lines and lines starting with # are ignored.
+func (pc *PathCosts) Load(file string) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := pc.Set(strings.Fields(line)); err != nil {
+			return fmt.Errorf("%s:%d: %v", file, i+1, err)
+		}
+	}
+	return nil
+}
+
+// Edge returns the cost of taking the edge.
+func (pc *PathCosts) Edge(e StatusEdge) int {
+	if pc == nil {
+		return 1
+	}
+	pc.Lock()
+	defer pc.Unlock()
+	return 1 + pc.transitions[strings.ToLower(e.Name)] + pc.statuses[strings.ToLower(e.Status.Name)]
+}
+
+// PathOptions control the search of EdgePath and ViaPath.
+type PathOptions struct {
+	// Avoid lists statuses the path must not pass through.
+	Avoid []string
+
+	// Exclude maps lower-cased names of transitions that must not be used to
+	// the reason they are excluded.
+	Exclude map[string]string
+
+	// Costs are the costs of the transitions and statuses. If nil, all
+	// transitions cost 1.
+	Costs *PathCosts
+
+	// Limit is the max number of verteces to search. A negative limit results
+	// in path executing without a limit.
+	Limit int
+}
+
+// PathError is returned when no path could be found, listing the
+// alternatives that were considered.
+type PathError struct {
+	From, To string
+
+	// Reached lists the statuses that could be reached.
+	Reached []string
+
+	// Excluded lists the transitions that were excluded, with the reason.
+	Excluded []string
+}
+
+func (pe *PathError) Error() string {
+	s := fmt.Sprintf("path not found from %s to %s", pe.From, pe.To)
+	if len(pe.Reached) > 0 {
+		s += fmt.Sprintf("; reachable statuses: %s", strings.Join(pe.Reached, ", "))
+	}
+	if len(pe.Excluded) > 0 {
+		s += fmt.Sprintf("; excluded transitions: %s", strings.Join(pe.Excluded, ", "))
+	}
+	return s
+}
+
+// Path finds the cheapest path in the workflow graph from A to B, searching at
 // most limit verteces. A negative limit results in path executing without a
 // limit. Cycles are detected and terminated, so the limit is just to avoid high
 // searching times in *very* large graphs. A and B are case insensitive for
 // convenience.
 func (wg *WorkflowGraph) Path(A, B string, limit int) ([]string, error) {
+	edges, err := wg.EdgePath(A, B, &PathOptions{Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+
+	var s []string
+	for _, e := range edges {
+		s = append(s, e.Name)
+	}
+	return s, nil
+}
+
+type pathStep struct {
+	from *Status
+	edge StatusEdge
+}
+
+// EdgePath finds the cheapest path in the workflow graph from A to B,
+// returning the edges of the path. The cost of a path is the sum of the costs
+// of its edges, as given by opts.Costs.
+func (wg *WorkflowGraph) EdgePath(A, B string, opts *PathOptions) ([]StatusEdge, error) {
 	statusA := wg.verteces[strings.ToLower(A)]
 	statusB := wg.verteces[strings.ToLower(B)]
 
@@ -221,48 +696,304 @@ func (wg *WorkflowGraph) Path(A, B string, limit int) ([]string, error) {
 		return nil, errors.New("no such status")
 	}
 
-	visited := make(map[string]bool)
-
-	var search []path
-	for _, edge := range statusA.Edges {
-		search = append(search, path{edge: edge})
+	avoided := make(map[*Status]bool)
+	for _, a := range opts.Avoid {
+		if s := wg.verteces[strings.ToLower(a)]; s != nil {
+			if s == statusB {
+				return nil, errors.New("target status is avoided")
+			}
+			avoided[s] = true
+		}
 	}
 
-	for len(search) > 0 {
+	dist := map[*Status]int{statusA: 0}
+	prev := make(map[*Status]pathStep)
+	done := make(map[*Status]bool)
+	excluded := make(map[string]bool)
+	limit := opts.Limit
+
+	pe := &PathError{From: statusA.Name, To: statusB.Name}
+
+	for {
+		// Pick the cheapest unvisited vertice, using the name to break ties
+		// to keep the result stable.
+		var cur *Status
+		for s, d := range dist {
+			if done[s] {
+				continue
+			}
+			if cur == nil || d < dist[cur] || (d == dist[cur] && s.Name < cur.Name) {
+				cur = s
+			}
+		}
+		if cur == nil {
+			break
+		}
+
+		// FOUND!
+		if cur == statusB {
+			var edges []StatusEdge
+			for s := cur; s != statusA; s = prev[s].from {
+				edges = append([]StatusEdge{prev[s].edge}, edges...)
+			}
+			return edges, nil
+		}
+
+		done[cur] = true
+		if cur != statusA {
+			pe.Reached = append(pe.Reached, cur.Name)
+		}
+
 		limit--
 		if limit == 0 {
 			break
 		}
-		p := search[0]
-		search = search[1:]
 
-		// FOUND!
-		if p.edge.Status == statusB {
-			var s []string
-			start := &p
-
-			for {
-				s = append([]string{start.edge.Name}, s...)
-				if start.from == nil {
-					break
+		for _, edge := range cur.Edges {
+			if reason, exists := opts.Exclude[strings.ToLower(edge.Name)]; exists {
+				if !excluded[edge.Name] {
+					excluded[edge.Name] = true
+					pe.Excluded = append(pe.Excluded, fmt.Sprintf("%s (%s)", edge.Name, reason))
 				}
+				continue
+			}
+			if avoided[edge.Status] || done[edge.Status] {
+				continue
+			}
 
-				start = start.from
+			d := dist[cur] + opts.Costs.Edge(edge)
+			if old, exists := dist[edge.Status]; !exists || d < old {
+				dist[edge.Status] = d
+				prev[edge.Status] = pathStep{from: cur, edge: edge}
 			}
-			return s, nil
 		}
+	}
 
-		if visited[p.edge.Status.ID] {
-			// We have already walked all edges of this vertice.
+	return nil, pe
+}
+
+// ViaPath finds the cheapest path from A to B that passes through the statuses
+// in via in order.
+func (wg *WorkflowGraph) ViaPath(A, B string, via []string, opts *PathOptions) ([]StatusEdge, error) {
+	var edges []StatusEdge
+	from := A
+	targets := append(append([]string{}, via...), B)
+	for _, to := range targets {
+		e, err := wg.EdgePath(from, to, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s -> %s: %v", from, to, err)
+		}
+		edges = append(edges, e...)
+		from = to
+	}
+	return edges, nil
+}
+
+// UnfillableTransitions returns the transitions with required fields that
+// have no default value, mapped by lower-cased name to the reason. As jirafs
+// cannot fill transition screens, these transitions cannot be executed. JIRA
+// only describes the screens of the transitions available from the current
+// status, so screens of later steps in a path are checked as they are reached.
+func UnfillableTransitions(trs []Transition) map[string]string {
+	exclude := make(map[string]string)
+	for _, tr := range trs {
+		var missing []string
+		for id, f := range tr.Fields {
+			if f.Required && !f.HasDefaultValue {
+				missing = append(missing, id)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			exclude[strings.ToLower(tr.Name)] = "requires " + strings.Join(missing, ", ")
+		}
+	}
+	return exclude
+}
+
+// StatusStep is a single transition in a planned status change.
+type StatusStep struct {
+	From       string
+	To         string
+	Transition string
+}
+
+func (ss StatusStep) String() string {
+	return fmt.Sprintf("%s: %s -> %s", ss.Transition, ss.From, ss.To)
+}
+
+// ParseStatusTarget parses status change arguments in the form "status [--via
+// a,b] [--avoid c,d]". Status names may contain spaces.
+func ParseStatusTarget(args []string) (target string, via, avoid []string, err error) {
+	var cur *[]string
+	var targetWords, words []string
+	flush := func() {
+		if cur == nil {
+			targetWords = words
+		} else {
+			for _, s := range strings.Split(strings.Join(words, " "), ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					*cur = append(*cur, s)
+				}
+			}
+		}
+		words = nil
+	}
+
+	for _, arg := range args {
+		switch arg {
+		case "--via":
+			flush()
+			cur = &via
+		case "--avoid":
+			flush()
+			cur = &avoid
+		default:
+			if arg != "" {
+				words = append(words, arg)
+			}
+		}
+	}
+	flush()
+
+	target = strings.Join(targetWords, " ")
+	if target == "" {
+		return "", nil, nil, errors.New("target status missing")
+	}
+	return target, via, avoid, nil
+}
+
+// StatusPlan is the sequence of transitions needed to change the status of an
+// issue.
+type StatusPlan struct {
+	Issue     string
+	Project   string
+	IssueType string
+	Steps     []StatusStep
+}
+
+func (sp *StatusPlan) String() string {
+	var s string
+	for _, step := range sp.Steps {
+		s += step.String() + "\n"
+	}
+	return s
+}
+
+// PlanStatusChange finds the transitions needed to bring the issue to the
+// target status, without executing them.
+func PlanStatusChange(jc *Client, issueKey, target string, via, avoid []string) (*StatusPlan, error) {
+	issue, err := GetIssue(jc, issueKey)
+	if err != nil {
+		return nil, err
+	}
+	if issue.Fields == nil || issue.Fields.Status == nil {
+		return nil, errors.New("issue missing status")
+	}
+
+	sp := &StatusPlan{
+		Issue:     issue.Key,
+		Project:   issue.Fields.Project.Key,
+		IssueType: issue.Fields.Type.ID,
+	}
+
+	wg, err := GetWorkflow(jc, sp.Project, sp.IssueType, issue.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	trs, err := GetTransitionsForIssue(jc, issue.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &PathOptions{
+		Avoid:   avoid,
+		Exclude: UnfillableTransitions(trs),
+		Costs:   &jc.costs,
+		Limit:   500,
+	}
+
+	edges, err := wg.ViaPath(issue.Fields.Status.Name, target, via, opts)
+	if err != nil {
+		log.Printf("Workflow: \n%s\n", wg.Dump())
+		// The cached workflow may be outdated.
+		InvalidateWorkflow(jc, sp.Project, sp.IssueType)
+		return nil, err
+	}
+
+	from := issue.Fields.Status.Name
+	for _, e := range edges {
+		sp.Steps = append(sp.Steps, StatusStep{From: from, To: e.Status.Name, Transition: e.Name})
+		from = e.Status.Name
+	}
+	return sp, nil
+}
+
+// Execute issues the transitions of the plan in order. The screen of each
+// transition is checked before it is issued, and execution stops at the first
+// transition that is unavailable or has required fields that cannot be filled.
+func (sp *StatusPlan) Execute(jc *Client) error {
+	var names []string
+	for _, s := range sp.Steps {
+		names = append(names, s.Transition)
+	}
+	log.Printf("Workflow path: %s", strings.Join(names, ", "))
+
+	// Once a step is queued, the remaining steps are queued behind it, and
+	// cannot be checked against the issue until they are replayed.
+	var queued error
+	for i, s := range sp.Steps {
+		if !jc.Offline() && !jc.queue.Pending(sp.Issue) {
+			if err := sp.checkStep(jc, s); err != nil {
+				log.Printf("Could not transition issue: %v", err)
+				InvalidateWorkflow(jc, sp.Project, sp.IssueType)
+				return fmt.Errorf("stopped at %s after %d of %d steps: %v", s.From, i, len(sp.Steps), err)
+			}
+		}
+		err := TransitionIssue(jc, sp.Issue, s.Transition)
+		if IsQueued(err) {
+			queued = err
 			continue
 		}
-		visited[p.edge.Status.ID] = true
+		if err != nil {
+			log.Printf("Could not transition issue: %v", err)
+			InvalidateWorkflow(jc, sp.Project, sp.IssueType)
+			return fmt.Errorf("stopped at %s after %d of %d steps: %v", s.From, i, len(sp.Steps), err)
+		}
+	}
+
+	return queued
+}
 
-		// Add the edges to the search.
-		for _, edge := range p.edge.Status.Edges {
-			search = append(search, path{from: &p, edge: edge})
+// checkStep verifies that the transition of the step is available on the
+// issue, and that its screen has no required fields that jirafs cannot fill.
+func (sp *StatusPlan) checkStep(jc *Client, s StatusStep) error {
+	trs, err := GetTransitionsForIssue(jc, sp.Issue)
+	if err != nil {
+		return err
+	}
+
+	for _, tr := range trs {
+		if tr.Name != s.Transition {
+			continue
+		}
+		if reason, exists := UnfillableTransitions([]Transition{tr})[strings.ToLower(tr.Name)]; exists {
+			return fmt.Errorf("transition %s %s", s.Transition, reason)
 		}
+		return nil
+	}
+	return fmt.Errorf("transition %s is not available", s.Transition)
+}
+
+// ChangeStatus brings the issue to the target status, executing the
+// transitions found by PlanStatusChange in order.
+func ChangeStatus(jc *Client, issueKey, target string, via, avoid []string) error {
+	sp, err := PlanStatusChange(jc, issueKey, target, via, avoid)
+	if err != nil {
+		log.Printf("Could not find path: %v", err)
+		return err
 	}
 
-	return nil, errors.New("path not found")
+	return sp.Execute(jc)
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testWorkflow builds a graph from edges in the form "From -> To: Name".
+func testWorkflow(edges ...string) *WorkflowGraph {
+	wg := &WorkflowGraph{verteces: make(map[string]*Status)}
+	status := func(name string) *Status {
+		s, exists := wg.verteces[strings.ToLower(name)]
+		if !exists {
+			s = &Status{Name: name}
+			wg.verteces[strings.ToLower(name)] = s
+		}
+		return s
+	}
+
+	for _, e := range edges {
+		idx := strings.Index(e, ": ")
+		ends := strings.Split(e[:idx], " -> ")
+		from, to := status(ends[0]), status(ends[1])
+		from.Edges = append(from.Edges, StatusEdge{Name: e[idx+2:], Status: to})
+	}
+	return wg
+}
+
+func TestEdgePath(t *testing.T) {
+	wg := testWorkflow(
+		"Open -> In Progress: Start",
+		"In Progress -> Review: Submit",
+		"Review -> Done: Approve",
+		"In Progress -> Open: Stop",
+		"Open -> Done: Close",
+		"Done -> Open: Reopen",
+	)
+
+	costs := func(args ...string) *PathCosts {
+		pc := &PathCosts{}
+		for _, a := range args {
+			if err := pc.Set(strings.Fields(a)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return pc
+	}
+
+	tests := []struct {
+		name     string
+		from, to string
+		opts     PathOptions
+		want     []string
+		err      string
+	}{
+		{"direct", "Open", "Done", PathOptions{Limit: -1}, []string{"Close"}, ""},
+		{"case insensitive", "open", "DONE", PathOptions{Limit: -1}, []string{"Close"}, ""},
+		{"same status", "Open", "Open", PathOptions{Limit: -1}, nil, ""},
+		{"multi step", "Review", "Open", PathOptions{Limit: -1}, []string{"Approve", "Reopen"}, ""},
+		{"transition cost", "Open", "Done", PathOptions{Limit: -1, Costs: costs("transition 5 Close")},
+			[]string{"Start", "Submit", "Approve"}, ""},
+		{"status cost", "Open", "Done", PathOptions{Limit: -1, Costs: costs("transition 5 Close", "status 9 Review")},
+			[]string{"Close"}, ""},
+		{"excluded", "Open", "Done", PathOptions{Limit: -1, Exclude: map[string]string{"close": "has required fields"}},
+			[]string{"Start", "Submit", "Approve"}, ""},
+		{"avoided", "Open", "Done", PathOptions{Limit: -1, Avoid: []string{"Review"}, Costs: costs("transition 5 Close")},
+			[]string{"Close"}, ""},
+		{"no path", "Open", "Done", PathOptions{Limit: -1, Avoid: []string{"review"}, Exclude: map[string]string{"close": "has required fields"}},
+			nil, "path not found from Open to Done; reachable statuses: In Progress; excluded transitions: Close (has required fields)"},
+		{"target avoided", "Open", "Done", PathOptions{Limit: -1, Avoid: []string{"Done"}}, nil, "target status is avoided"},
+		{"unknown status", "Open", "Nowhere", PathOptions{Limit: -1}, nil, "no such status"},
+		{"limit", "Open", "Done", PathOptions{Limit: 1}, nil, "path not found from Open to Done"},
+	}
+
+	for _, tt := range tests {
+		edges, err := wg.EdgePath(tt.from, tt.to, &tt.opts)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: err = %v, want %s", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+
+		var got []string
+		for _, e := range edges {
+			got = append(got, e.Name)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: path = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}